@@ -9,6 +9,8 @@
 // Включает также логику для плавного завершения работы сервера с обработкой сигналов остановки
 // (SIGTERM, SIGINT) и корректным завершением соединений.
 //
+// Веса разделения трафика между пулами перечитываются из конфигурации без перезапуска.
+//
 // Реализует корректное завершение работы с использованием контекста и каналов
 package app
 
@@ -43,8 +45,20 @@ func Run() {
 	}
 
 	repos := repository.NewRepository(redis)
-	services := services.NewServices(ctx, repos, *cfg)
-	handlers := handler.NewHandler(services)
+	services, err := services.NewServices(ctx, repos, *cfg)
+	if err != nil {
+		log.Fatalf("[MAIN] services initialisation error: %s", err)
+	}
+
+	config.Watch(func(newCfg *config.Config) {
+		if err := services.UpdateTrafficSplit(newCfg.Balancer.Routes); err != nil {
+			log.Printf("[MAIN] Error applying traffic split from config: %s", err)
+			return
+		}
+		log.Println("[MAIN] Traffic split weights reloaded")
+	})
+
+	handlers := handler.NewHandler(services, cfg.Balancer.Forwarding)
 
 	srv := server.NewServer(cfg.HTTP, handlers)
 
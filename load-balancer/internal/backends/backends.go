@@ -1,26 +1,84 @@
 // Package backends реализует структуру для представления бекенд-узлов, которые используются
 // в качестве целей для проксирования запросов в балансировщике нагрузки.
 //
-// Каждый бэкенд содержит информацию о своем URL, состоянии "живости" (Alive),
-// а также обратный прокси для обработки запросов, направленных на данный бэкенд.
+// Каждый бэкенд содержит информацию о своем URL, весе, состоянии "живости" (Alive),
+// количестве запросов в обработке, скользящем среднем времени ответа, порогах смены состояния,
+// результате последней проверки, параметрах плавного ввода в ротацию (slow start), а также обратный прокси для обработки запросов,
+// направленных на данный бэкенд.
 package backends
 
 import (
+	"math"
 	"net/http/httputil"
 	"net/url"
+	"sync"
 	"sync/atomic"
+	"time"
+
+	"github.com/mirskow/load-balancer/internal/events"
 )
 
+// latencyDecay - постоянная времени затухания скользящего среднего времени ответа.
+const latencyDecay = 10 * time.Second
+
+// ProbeResult описывает результат проверки состояния бэкенда.
+type ProbeResult struct {
+	Time     time.Time     // время начала проверки
+	Duration time.Duration // длительность проверки
+	Err      error         // nil, если проверка успешна
+}
+
+// Healthy возвращает true, если проверка завершилась успешно.
+func (p ProbeResult) Healthy() bool {
+	return p.Err == nil
+}
+
+// healthCounters - счетчики успехов и ошибок подряд для одного источника сведений о состоянии.
+type healthCounters struct {
+	successes int
+	failures  int
+}
+
 type Backend struct {
 	URL          *url.URL
 	Alive        atomic.Bool
 	ReverseProxy *httputil.ReverseProxy
+
+	weight         int          // относительная доля трафика для взвешенных стратегий
+	activeRequests atomic.Int64 // количество запросов, проксируемых на бэкенд в данный момент
+	upgradedConns  atomic.Int64 // количество соединений после смены протокола (WebSocket и т.п.)
+	ejectedUntil   atomic.Int64 // время (UnixNano), до которого бэкенд извлечен из ротации
+	lastProbe      atomic.Pointer[ProbeResult]
+
+	events *events.Bus // шина для событий о смене состояния, может быть nil
+
+	slowStartWindow time.Duration // длительность плавного ввода в ротацию после восстановления
+	slowStartMin    float64       // начальная доля веса в начале плавного ввода
+	recoveredAt     atomic.Int64  // время (UnixNano) последнего перехода из down в up
+
+	healthMu           sync.Mutex
+	healthyThreshold   int            // сколько успехов подряд нужно, чтобы бэкенд стал живым
+	unhealthyThreshold int            // сколько ошибок подряд нужно, чтобы бэкенд стал нерабочим
+	probe              healthCounters // результаты активных проверок
+	passive            healthCounters // результаты проксирования реального трафика
+
+	latencyMu      sync.Mutex
+	latencyEWMA    float64   // пиковое скользящее среднее времени ответа в наносекундах
+	latencyUpdated time.Time // время последнего обновления latencyEWMA
 }
 
-func NewBackend(url *url.URL, proxy *httputil.ReverseProxy) *Backend {
+// NewBackend создает живой бэкенд. Вес меньше 1 считается равным 1.
+func NewBackend(url *url.URL, weight int, proxy *httputil.ReverseProxy) *Backend {
+	if weight < 1 {
+		weight = 1
+	}
+
 	b := &Backend{
-		URL:          url,
-		ReverseProxy: proxy,
+		URL:                url,
+		ReverseProxy:       proxy,
+		weight:             weight,
+		healthyThreshold:   1,
+		unhealthyThreshold: 1,
 	}
 
 	b.Alive.Store(true)
@@ -32,7 +90,236 @@ func (b *Backend) IsAlive() (alive bool) {
 	return b.Alive.Load()
 }
 
+// SetEventBus задает шину, в которую публикуются события о смене состояния бэкенда.
+func (b *Backend) SetEventBus(bus *events.Bus) {
+	b.events = bus
+}
+
 // Используется для обновления флага Alive в случае изменения состояния бэкенда.
-func (b *Backend) SetAlive(alive bool) {
-	b.Alive.Store(alive)
+// Устанавливает состояние сразу, без учета порогов, и сбрасывает счетчики успехов и ошибок.
+func (b *Backend) SetAlive(alive bool, reason events.Reason) {
+	b.healthMu.Lock()
+	defer b.healthMu.Unlock()
+
+	b.probe = healthCounters{}
+	b.passive = healthCounters{}
+	b.setAliveLocked(alive, reason, nil)
+}
+
+// SetThresholds задает, сколько успехов (healthy) и ошибок (unhealthy) подряд нужно для смены состояния.
+// Значения меньше 1 считаются равными 1.
+func (b *Backend) SetThresholds(healthy, unhealthy int) {
+	b.healthMu.Lock()
+	defer b.healthMu.Unlock()
+
+	b.healthyThreshold = max(healthy, 1)
+	b.unhealthyThreshold = max(unhealthy, 1)
+}
+
+// ReportSuccess учитывает успешную проверку или успешный запрос.
+// Возвращает true, если бэкенд после этого стал живым.
+//
+// Успехи активных проверок и реального трафика считаются раздельно: успешный запрос сбрасывает
+// только счетчик ошибок проксирования и не мешает проверкам набрать порог ошибок.
+// Вернуть бэкенд в ротацию могут только проверки.
+func (b *Backend) ReportSuccess(reason events.Reason) (changed bool) {
+	b.healthMu.Lock()
+	defer b.healthMu.Unlock()
+
+	counters := b.countersFor(reason)
+	counters.failures = 0
+	counters.successes++
+
+	if counters == &b.probe && counters.successes >= b.healthyThreshold {
+		return b.setAliveLocked(true, reason, nil)
+	}
+	return false
+}
+
+// ReportFailure учитывает неудачную проверку или ошибку проксирования.
+// Возвращает true, если бэкенд после этого стал нерабочим.
+func (b *Backend) ReportFailure(reason events.Reason, err error) (changed bool) {
+	b.healthMu.Lock()
+	defer b.healthMu.Unlock()
+
+	counters := b.countersFor(reason)
+	counters.successes = 0
+	counters.failures++
+
+	if counters.failures >= b.unhealthyThreshold {
+		return b.setAliveLocked(false, reason, err)
+	}
+	return false
+}
+
+// countersFor возвращает счетчики источника: проверок для ReasonHealthCheck и ReasonAdmin,
+// реального трафика - для остальных причин. Вызывается под healthMu.
+func (b *Backend) countersFor(reason events.Reason) *healthCounters {
+	switch reason {
+	case events.ReasonHealthCheck, events.ReasonAdmin:
+		return &b.probe
+	default:
+		return &b.passive
+	}
+}
+
+// setAliveLocked меняет флаг Alive и публикует событие, если состояние действительно изменилось.
+// При смене состояния счетчики обоих источников сбрасываются: пороги отсчитываются заново.
+// Вызывается под healthMu.
+func (b *Backend) setAliveLocked(alive bool, reason events.Reason, err error) (changed bool) {
+	if b.Alive.Swap(alive) == alive {
+		return false
+	}
+
+	b.probe = healthCounters{}
+	b.passive = healthCounters{}
+
+	if alive {
+		b.recoveredAt.Store(time.Now().UnixNano())
+	}
+
+	e := events.Event{
+		Backend: b.URL.String(),
+		From:    events.StateDown,
+		To:      events.StateUp,
+		Reason:  reason,
+		Time:    time.Now(),
+	}
+	if !alive {
+		e.From, e.To = events.StateUp, events.StateDown
+	}
+	if err != nil {
+		e.Error = err.Error()
+	}
+
+	b.events.Publish(e)
+	return true
+}
+
+// Weight возвращает вес бэкенда.
+func (b *Backend) Weight() int {
+	return b.weight
+}
+
+// SetLastProbe сохраняет результат последней проверки состояния.
+func (b *Backend) SetLastProbe(result ProbeResult) {
+	b.lastProbe.Store(&result)
+}
+
+// LastProbe возвращает результат последней проверки состояния.
+// Второе значение равно false, если бэкенд еще ни разу не проверялся.
+func (b *Backend) LastProbe() (ProbeResult, bool) {
+	result := b.lastProbe.Load()
+	if result == nil {
+		return ProbeResult{}, false
+	}
+	return *result, true
+}
+
+// Eject временно извлекает бэкенд из ротации на заданное время, не меняя флаг Alive.
+func (b *Backend) Eject(d time.Duration) {
+	b.ejectedUntil.Store(time.Now().Add(d).UnixNano())
+}
+
+// IsEjected возвращает true, если бэкенд временно извлечен из ротации.
+func (b *Backend) IsEjected() bool {
+	return time.Now().UnixNano() < b.ejectedUntil.Load()
+}
+
+// SetSlowStart задает плавный ввод в ротацию: в течение window после восстановления
+// эффективный вес бэкенда линейно растет от minFraction до полного. Нулевое window отключает плавный ввод.
+func (b *Backend) SetSlowStart(window time.Duration, minFraction float64) {
+	b.slowStartWindow = window
+	b.slowStartMin = min(max(minFraction, 0), 1)
+}
+
+// SlowStartFactor возвращает долю веса бэкенда в диапазоне (0, 1] с учетом плавного ввода в ротацию.
+func (b *Backend) SlowStartFactor() float64 {
+	recoveredAt := b.recoveredAt.Load()
+	if b.slowStartWindow <= 0 || recoveredAt == 0 {
+		return 1
+	}
+
+	elapsed := time.Since(time.Unix(0, recoveredAt))
+	if elapsed >= b.slowStartWindow {
+		return 1
+	}
+
+	factor := b.slowStartMin + (1-b.slowStartMin)*float64(elapsed)/float64(b.slowStartWindow)
+	return max(factor, math.SmallestNonzeroFloat64)
+}
+
+// EffectiveWeight возвращает вес бэкенда с учетом плавного ввода в ротацию.
+func (b *Backend) EffectiveWeight() float64 {
+	return float64(b.weight) * b.SlowStartFactor()
+}
+
+// IncActiveRequests увеличивает счетчик запросов в обработке. Вызывается перед проксированием.
+func (b *Backend) IncActiveRequests() {
+	b.activeRequests.Add(1)
+}
+
+// DecActiveRequests уменьшает счетчик запросов в обработке. Вызывается после проксирования.
+func (b *Backend) DecActiveRequests() {
+	b.activeRequests.Add(-1)
+}
+
+// ActiveRequests возвращает количество запросов, которые бэкенд обрабатывает в данный момент.
+func (b *Backend) ActiveRequests() int64 {
+	return b.activeRequests.Load()
+}
+
+// IncUpgradedConnections увеличивает счетчик соединений после смены протокола.
+func (b *Backend) IncUpgradedConnections() {
+	b.upgradedConns.Add(1)
+}
+
+// DecUpgradedConnections уменьшает счетчик соединений после смены протокола. Вызывается при закрытии соединения.
+func (b *Backend) DecUpgradedConnections() {
+	b.upgradedConns.Add(-1)
+}
+
+// UpgradedConnections возвращает количество открытых долгоживущих соединений (WebSocket и т.п.) с бэкендом.
+// Такие соединения учитываются и в ActiveRequests на все время их жизни.
+func (b *Backend) UpgradedConnections() int64 {
+	return b.upgradedConns.Load()
+}
+
+// ObserveLatency учитывает время ответа бэкенда в пиковом скользящем среднем (Peak EWMA):
+// рост задержки учитывается сразу, а снижение - плавно, с затуханием по времени.
+func (b *Backend) ObserveLatency(rtt time.Duration) {
+	b.latencyMu.Lock()
+	defer b.latencyMu.Unlock()
+
+	now := time.Now()
+	sample := float64(rtt)
+
+	w := b.decayWeight(now)
+	if sample > b.latencyEWMA*w {
+		b.latencyEWMA = sample
+	} else {
+		b.latencyEWMA = b.latencyEWMA*w + sample*(1-w)
+	}
+
+	b.latencyUpdated = now
+}
+
+// LatencyEWMA возвращает текущее значение скользящего среднего времени ответа.
+// Если бэкенд давно не получал запросов, значение затухает к нулю,
+// чтобы медленный в прошлом бэкенд снова получил шанс.
+func (b *Backend) LatencyEWMA() time.Duration {
+	b.latencyMu.Lock()
+	defer b.latencyMu.Unlock()
+
+	return time.Duration(b.latencyEWMA * b.decayWeight(time.Now()))
+}
+
+// decayWeight возвращает вес старого значения среднего с учетом прошедшего времени.
+func (b *Backend) decayWeight(now time.Time) float64 {
+	if b.latencyUpdated.IsZero() {
+		return 0
+	}
+
+	elapsed := now.Sub(b.latencyUpdated)
+	return math.Exp(-float64(elapsed) / float64(latencyDecay))
 }
@@ -1,12 +1,19 @@
 // Config представляет конфигурацию всего приложения.
-// Она включает настройки для HTTP сервера, балансировщика нагрузки, лимитера запросов и Redis.
+// Она включает настройки для HTTP сервера, балансировщика нагрузки, лимитера запросов, Redis
+// и доставки событий о смене состояния бэкендов.
 //
 // Для парсинга конфигурационного файла используется библиотека viper.
 package config
 
 import (
+	"fmt"
+	"log"
+	"reflect"
+	"strings"
 	"time"
 
+	"github.com/fsnotify/fsnotify"
+	"github.com/go-viper/mapstructure/v2"
 	"github.com/spf13/viper"
 )
 
@@ -16,6 +23,7 @@ type (
 		Balancer BalancerConfig `yaml:"balancer"`
 		Limiter  LimiterConfig  `yaml:"limiter"`
 		Redis    RedisConfig    `yaml:"redis"`
+		Events   EventsConfig   `yaml:"events"`
 	}
 
 	HTTPConfig struct {
@@ -26,8 +34,273 @@ type (
 	}
 
 	BalancerConfig struct {
-		Backends        []string      `yaml:"backends"`
-		HealthCheckTime time.Duration `yaml:"healthCheckTime"`
+		Strategy        string              `yaml:"strategy"`
+		Hash            HashConfig          `yaml:"hash"`
+		P2C             P2CConfig           `yaml:"p2c"`
+		StickySession   StickySessionConfig `yaml:"stickySession"`
+		Backends        []BackendConfig     `yaml:"backends"`
+		HealthCheckTime time.Duration       `yaml:"healthCheckTime"`
+		HealthCheck     HealthCheckConfig   `yaml:"healthCheck"`
+
+		// HealthCheckJitter - максимальная случайная задержка, добавляемая к интервалу проверок.
+		HealthCheckJitter time.Duration `yaml:"healthCheckJitter"`
+		// HealthCheckConcurrency - максимальное число одновременных проверок.
+		HealthCheckConcurrency int `yaml:"healthCheckConcurrency"`
+
+		OutlierDetection OutlierDetectionConfig `yaml:"outlierDetection"`
+		SlowStart        SlowStartConfig        `yaml:"slowStart"`
+		CircuitBreaker   CircuitBreakerConfig   `yaml:"circuitBreaker"`
+		Retry            RetryConfig            `yaml:"retry"`
+		Hedging          HedgingConfig          `yaml:"hedging"`
+		Transport        TransportConfig        `yaml:"transport"`
+		Forwarding       ForwardingConfig       `yaml:"forwarding"`
+		Upgrade          UpgradeConfig          `yaml:"upgrade"`
+		Pools            []PoolConfig           `yaml:"pools" mapstructure:"-"`
+		Routes           []RouteConfig          `yaml:"routes"`
+	}
+
+	// PoolConfig задает именованный пул бэкендов со своей стратегией, проверками и прочими настройками.
+	// Незаданные настройки пул наследует от секции balancer, кроме списка бэкендов и имени cookie привязки:
+	// без своего имени пул получает имя cookie секции balancer с суффиксом из имени пула.
+	PoolConfig struct {
+		Name           string `yaml:"name"`
+		BalancerConfig `yaml:",inline"`
+	}
+
+	// RouteConfig задает маршрут: запрос, удовлетворяющий всем заданным условиям, направляется в пул Pool
+	// или делится между пулами по Split.
+	// Host допускает шаблон вида *.example.com. Маршруты проверяются по порядку, побеждает первый подходящий.
+	RouteConfig struct {
+		Name       string        `yaml:"name"`
+		Host       string        `yaml:"host"`
+		PathPrefix string        `yaml:"pathPrefix"`
+		PathRegex  string        `yaml:"pathRegex"`
+		Methods    []string      `yaml:"methods"`
+		Headers    []MatchConfig `yaml:"headers"`
+		Query      []MatchConfig `yaml:"query"`
+		Cookies    []MatchConfig `yaml:"cookies"`
+		Pool       string        `yaml:"pool"`
+		Split      SplitConfig   `yaml:"split"`
+		Rewrite    RewriteConfig `yaml:"rewrite"`
+	}
+
+	// SplitConfig делит трафик маршрута между пулами пропорционально весам (задается вместо Pool).
+	// Sticky закрепляет клиента за вариантом: cookie (имя CookieName на время CookieTTL) или ip (хеш IP клиента).
+	// Cookie варианта подписывается ключом StickySession.SigningKey секции balancer.
+	// Заголовок OverrideHeader с именем пула принудительно выбирает этот пул, если клиент пришел
+	// из одной из сетей OverrideTrustedNets (CIDR).
+	// Веса применяются без перезапуска при изменении файла конфигурации.
+	SplitConfig struct {
+		Pools               []SplitPoolConfig `yaml:"pools"`
+		Sticky              string            `yaml:"sticky"`
+		CookieName          string            `yaml:"cookieName"`
+		CookieTTL           time.Duration     `yaml:"cookieTTL"`
+		OverrideHeader      string            `yaml:"overrideHeader"`
+		OverrideTrustedNets []string          `yaml:"overrideTrustedNets"`
+	}
+
+	SplitPoolConfig struct {
+		Pool   string `yaml:"pool"`
+		Weight int    `yaml:"weight"`
+	}
+
+	// RewriteConfig задает преобразования запроса и ответа на маршруте. Путь преобразуется
+	// в порядке: StripPrefix, PathRegex -> PathReplacement (допускает $1), AddPrefix.
+	// Domains переписывает домен бэкенда в заголовках Location и Set-Cookie ответа.
+	RewriteConfig struct {
+		StripPrefix     string                `yaml:"stripPrefix"`
+		AddPrefix       string                `yaml:"addPrefix"`
+		PathRegex       string                `yaml:"pathRegex"`
+		PathReplacement string                `yaml:"pathReplacement"`
+		RequestHeaders  HeaderRewriteConfig   `yaml:"requestHeaders"`
+		ResponseHeaders HeaderRewriteConfig   `yaml:"responseHeaders"`
+		Domains         []DomainRewriteConfig `yaml:"domains"`
+	}
+
+	// HeaderRewriteConfig задает заголовки, которые нужно заменить (Set), добавить (Add) или удалить (Remove).
+	HeaderRewriteConfig struct {
+		Set    []HeaderValueConfig `yaml:"set"`
+		Add    []HeaderValueConfig `yaml:"add"`
+		Remove []string            `yaml:"remove"`
+	}
+
+	HeaderValueConfig struct {
+		Name  string `yaml:"name"`
+		Value string `yaml:"value"`
+	}
+
+	// DomainRewriteConfig заменяет домен бэкенда From на публичный домен To.
+	// Если To не задан, используется хост из запроса клиента.
+	DomainRewriteConfig struct {
+		From string `yaml:"from"`
+		To   string `yaml:"to"`
+	}
+
+	// MatchConfig задает условие на заголовок, параметр запроса или cookie с именем Name.
+	// Если Value и Regex не заданы, достаточно наличия значения.
+	MatchConfig struct {
+		Name  string `yaml:"name"`
+		Value string `yaml:"value"`
+		Regex string `yaml:"regex"`
+	}
+
+	// UpgradeConfig задает таймауты соединений после смены протокола (WebSocket, h2c).
+	// Таймауты http-сервера к таким соединениям не применяются. IdleTimeout закрывает соединение
+	// без трафика в обе стороны, MaxLifetime (0 - без ограничения) - соединение, живущее слишком долго.
+	// WebSocket при этом закрывается кадром Close с кодом 1001, остальные протоколы - разрывом соединения.
+	UpgradeConfig struct {
+		IdleTimeout time.Duration `yaml:"idleTimeout"`
+		MaxLifetime time.Duration `yaml:"maxLifetime"`
+	}
+
+	// ForwardingConfig включает заголовки, которые балансировщик добавляет в запросы к бэкендам.
+	// XForwardedFor по умолчанию (nil) включен, как и до появления этой секции, остальные - выключены.
+	// RequestID включает генерацию X-Request-ID (если клиент его не прислал) и его возврат клиенту.
+	ForwardingConfig struct {
+		XForwardedFor   *bool `yaml:"xForwardedFor"`
+		XForwardedProto bool  `yaml:"xForwardedProto"`
+		XForwardedHost  bool  `yaml:"xForwardedHost"`
+		Forwarded       bool  `yaml:"forwarded"`
+		RequestID       bool  `yaml:"requestID"`
+	}
+
+	// TransportConfig задает параметры соединений с бэкендами пула.
+	// HTTP2 разрешает HTTP/2 к бэкендам по TLS, H2C - HTTP/2 без TLS к бэкендам с http-адресом.
+	// С H2C ненулевой MaxConnsPerHost оставляет одно соединение на хост: запросы ждут свободного потока.
+	TransportConfig struct {
+		DialTimeout           time.Duration `yaml:"dialTimeout"`
+		KeepAlive             time.Duration `yaml:"keepAlive"`
+		ResponseHeaderTimeout time.Duration `yaml:"responseHeaderTimeout"`
+		IdleConnTimeout       time.Duration `yaml:"idleConnTimeout"`
+		MaxIdleConnsPerHost   int           `yaml:"maxIdleConnsPerHost"`
+		MaxConnsPerHost       int           `yaml:"maxConnsPerHost"`
+		HTTP2                 bool          `yaml:"http2"`
+		H2C                   bool          `yaml:"h2c"`
+	}
+
+	// HedgingConfig задает дублирование запросов: если безопасный запрос с путем, подходящим
+	// под регулярное выражение PathPattern, не получил заголовки ответа за Delay (или за перцентиль
+	// DelayPercentile наблюдаемого времени ответа), его копия отправляется на другой бэкенд.
+	HedgingConfig struct {
+		Enabled         bool          `yaml:"enabled"`
+		PathPattern     string        `yaml:"pathPattern"`
+		Methods         []string      `yaml:"methods"`
+		Delay           time.Duration `yaml:"delay"`
+		DelayPercentile int           `yaml:"delayPercentile"`
+	}
+
+	// RetryConfig задает повтор запроса на другом бэкенде при ошибке проксирования.
+	// Повторяются только запросы с методами из Methods (по умолчанию - идемпотентные)
+	// и телом не больше MaxBodyBytes. MaxAttempts - общее число попыток, включая первую.
+	// PerTryTimeout ограничивает ожидание заголовков ответа в каждой попытке повторяемого запроса.
+	RetryConfig struct {
+		Enabled       bool              `yaml:"enabled"`
+		MaxAttempts   int               `yaml:"maxAttempts"`
+		PerTryTimeout time.Duration     `yaml:"perTryTimeout"`
+		MaxBodyBytes  int64             `yaml:"maxBodyBytes"`
+		Methods       []string          `yaml:"methods"`
+		Budget        RetryBudgetConfig `yaml:"budget"`
+	}
+
+	// RetryBudgetConfig ограничивает повторы: за последние TTL их может быть не больше Percent процентов
+	// от успешных запросов плюс MinRetriesPerSec повторов в секунду (отрицательное значение - 0).
+	// TTL не может быть меньше секунды.
+	RetryBudgetConfig struct {
+		Percent          int           `yaml:"percent"`
+		MinRetriesPerSec int           `yaml:"minRetriesPerSec"`
+		TTL              time.Duration `yaml:"ttl"`
+	}
+
+	// CircuitBreakerConfig задает автоматический выключатель для каждого бэкенда.
+	// Выключатель открывается, если за скользящее окно Window было не меньше MinRequests запросов
+	// и доля ошибок (или медленных ответов дольше SlowCallDuration) достигла порога.
+	// Нулевые значения заменяются значениями по умолчанию, SlowCallDuration = 0 отключает учет задержки.
+	// Window не может быть меньше секунды.
+	CircuitBreakerConfig struct {
+		Enabled             bool          `yaml:"enabled"`
+		Window              time.Duration `yaml:"window"`
+		MinRequests         int           `yaml:"minRequests"`
+		ErrorRatePercent    int           `yaml:"errorRatePercent"`
+		SlowCallDuration    time.Duration `yaml:"slowCallDuration"`
+		SlowCallRatePercent int           `yaml:"slowCallRatePercent"`
+		OpenDuration        time.Duration `yaml:"openDuration"`
+		HalfOpenRequests    int           `yaml:"halfOpenRequests"`
+	}
+
+	// SlowStartConfig задает плавный ввод восстановленного бэкенда в ротацию: в течение Window
+	// его эффективный вес линейно растет от MinWeightPercent процентов до полного.
+	// Нулевое Window (по умолчанию) выключает плавный ввод.
+	SlowStartConfig struct {
+		Window           time.Duration `yaml:"window"`
+		MinWeightPercent int           `yaml:"minWeightPercent"`
+	}
+
+	// OutlierDetectionConfig задает пассивное обнаружение выбросов по реальному трафику.
+	// Нулевые значения заменяются значениями по умолчанию (как в Envoy).
+	OutlierDetectionConfig struct {
+		Enabled                  bool          `yaml:"enabled"`
+		Interval                 time.Duration `yaml:"interval"`
+		Consecutive5xx           int           `yaml:"consecutive5xx"`
+		ConsecutiveGatewayErrors int           `yaml:"consecutiveGatewayErrors"`
+		BaseEjectionTime         time.Duration `yaml:"baseEjectionTime"`
+		MaxEjectionTime          time.Duration `yaml:"maxEjectionTime"`
+		MaxEjectionPercent       int           `yaml:"maxEjectionPercent"`
+		SuccessRateMinimumHosts  int           `yaml:"successRateMinimumHosts"`
+		SuccessRateRequestVolume int           `yaml:"successRateRequestVolume"`
+		SuccessRateStdevFactor   float64       `yaml:"successRateStdevFactor"`
+	}
+
+	// HealthCheckConfig задает активную проверку состояния бэкенда. Глобальная конфигурация
+	// действует для всех бэкендов, а заданные поля конфигурации бэкенда ее переопределяют.
+	HealthCheckConfig struct {
+		Type        string `yaml:"type"`        // http (по умолчанию), tcp или grpc
+		GRPCService string `yaml:"grpcService"` // имя сервиса для grpc.health.v1.Health/Check
+
+		Path             string            `yaml:"path"`
+		Method           string            `yaml:"method"`
+		Headers          map[string]string `yaml:"headers"`
+		Host             string            `yaml:"host"`
+		ExpectedStatuses []string          `yaml:"expectedStatuses"` // коды и диапазоны: "200", "200-399"
+		BodyContains     string            `yaml:"bodyContains"`
+		BodyRegex        string            `yaml:"bodyRegex"`
+		Timeout          time.Duration     `yaml:"timeout"`
+
+		// Пороги смены состояния: сколько успехов (healthy) или ошибок (unhealthy) подряд нужно,
+		// чтобы бэкенд стал живым или нерабочим. Учитываются и проверки, и ошибки проксирования.
+		HealthyThreshold   int `yaml:"healthyThreshold"`
+		UnhealthyThreshold int `yaml:"unhealthyThreshold"`
+	}
+
+	// StickySessionConfig задает привязку клиента к бэкенду через подписанную cookie.
+	// Пул, унаследовавший CookieName от секции balancer, использует имя с суффиксом _<имя пула>.
+	StickySessionConfig struct {
+		Enabled    bool          `yaml:"enabled"`
+		CookieName string        `yaml:"cookieName"`
+		TTL        time.Duration `yaml:"ttl"`
+		SigningKey string        `yaml:"signingKey"`
+	}
+
+	// HashConfig задает ключ для хеширующих стратегий (consistent-hash, maglev):
+	// источник (ip, header, cookie, path), имя заголовка или cookie, число виртуальных узлов
+	// кольца и размер таблицы Maglev (простое число).
+	HashConfig struct {
+		Key          string `yaml:"key"`
+		Name         string `yaml:"name"`
+		VirtualNodes int    `yaml:"virtualNodes"`
+		TableSize    int    `yaml:"tableSize"`
+	}
+
+	// P2CConfig задает метрику нагрузки для стратегии p2c: inflight или latency.
+	P2CConfig struct {
+		Load string `yaml:"load"`
+	}
+
+	// BackendConfig описывает один бэкенд пула. В конфигурации бэкенд можно задать
+	// как объектом (url, weight), так и просто строкой с URL - тогда вес равен 1.
+	BackendConfig struct {
+		URL         string            `yaml:"url"`
+		Weight      int               `yaml:"weight"`
+		HealthCheck HealthCheckConfig `yaml:"healthCheck"`
 	}
 
 	LimiterConfig struct {
@@ -37,6 +310,20 @@ type (
 		RefillTime time.Duration `yaml:"refillTime"`
 	}
 
+	// EventsConfig задает доставку событий о смене состояния бэкендов.
+	EventsConfig struct {
+		Webhook WebhookConfig `yaml:"webhook"`
+	}
+
+	// WebhookConfig задает webhook для событий. Если URL пуст, webhook отключен.
+	WebhookConfig struct {
+		URL          string            `yaml:"url"`
+		Headers      map[string]string `yaml:"headers"`
+		Timeout      time.Duration     `yaml:"timeout"`
+		MaxRetries   int               `yaml:"maxRetries"`
+		RetryBackoff time.Duration     `yaml:"retryBackoff"`
+	}
+
 	RedisConfig struct {
 		Host string `yaml:"host"`
 		Port string `yaml:"port"`
@@ -56,6 +343,22 @@ func Init(configsDir string) (*Config, error) {
 	return &cfg, nil
 }
 
+// Watch отслеживает изменения файла конфигурации и передает в onChange заново разобранную конфигурацию.
+// Ошибки разбора логируются, onChange в этом случае не вызывается.
+func Watch(onChange func(*Config)) {
+	viper.OnConfigChange(func(e fsnotify.Event) {
+		var cfg Config
+		if err := unmarshal(&cfg); err != nil {
+			log.Printf("[CONFIG] Error reloading %s: %v\n", e.Name, err)
+			return
+		}
+
+		onChange(&cfg)
+	})
+
+	viper.WatchConfig()
+}
+
 func parseConfigFile(configsDir string) error {
 	viper.AddConfigPath(configsDir)
 	viper.SetConfigName("config")
@@ -72,9 +375,15 @@ func unmarshal(cfg *Config) error {
 		return err
 	}
 
-	if err := viper.UnmarshalKey("balancer", &cfg.Balancer); err != nil {
+	if err := viper.UnmarshalKey("balancer", &cfg.Balancer, viper.DecodeHook(balancerDecodeHook())); err != nil {
+		return err
+	}
+
+	pools, err := unmarshalPools(viper.GetStringMap("balancer"))
+	if err != nil {
 		return err
 	}
+	cfg.Balancer.Pools = pools
 
 	if err := viper.UnmarshalKey("limiter", &cfg.Limiter); err != nil {
 		return err
@@ -84,5 +393,112 @@ func unmarshal(cfg *Config) error {
 		return err
 	}
 
+	if err := viper.UnmarshalKey("events", &cfg.Events); err != nil {
+		return err
+	}
+
 	return nil
 }
+
+// unmarshalPools разбирает список пулов balancer.pools. Настройки каждого пула накладываются
+// на настройки секции balancer (вложенные секции объединяются по ключам), бэкенды и имя cookie
+// привязки не наследуются.
+func unmarshalPools(balancer map[string]any) ([]PoolConfig, error) {
+	rawPools, _ := balancer["pools"].([]any)
+	if len(rawPools) == 0 {
+		return nil, nil
+	}
+
+	defaults := make(map[string]any, len(balancer))
+	for key, value := range balancer {
+		switch key {
+		case "pools", "routes", "backends":
+		case "stickysession":
+			// Имя cookie привязки не наследуется: пул без своего имени получает имя с суффиксом пула.
+			if sticky, ok := value.(map[string]any); ok {
+				value = withoutKey(sticky, "cookieName")
+			}
+			defaults[key] = value
+		default:
+			defaults[key] = value
+		}
+	}
+
+	pools := make([]PoolConfig, 0, len(rawPools))
+	for i, rawPool := range rawPools {
+		poolMap, ok := rawPool.(map[string]any)
+		if !ok {
+			return nil, fmt.Errorf("balancer.pools[%d]: expected a map, got %T", i, rawPool)
+		}
+
+		var pool PoolConfig
+		decoder, err := mapstructure.NewDecoder(&mapstructure.DecoderConfig{
+			DecodeHook:       balancerDecodeHook(),
+			WeaklyTypedInput: true,
+			Result:           &pool.BalancerConfig,
+		})
+		if err != nil {
+			return nil, err
+		}
+
+		if err := decoder.Decode(mergeMaps(defaults, poolMap)); err != nil {
+			return nil, fmt.Errorf("balancer.pools[%d]: %w", i, err)
+		}
+
+		pool.Name, _ = poolMap["name"].(string)
+		pools = append(pools, pool)
+	}
+
+	return pools, nil
+}
+
+// withoutKey возвращает копию карты без ключа name (без учета регистра: viper приводит ключи к нижнему регистру).
+func withoutKey(m map[string]any, name string) map[string]any {
+	rest := make(map[string]any, len(m))
+	for key, value := range m {
+		if !strings.EqualFold(key, name) {
+			rest[key] = value
+		}
+	}
+	return rest
+}
+
+// mergeMaps возвращает новую карту: значения override заменяют значения base, вложенные карты объединяются рекурсивно.
+func mergeMaps(base, override map[string]any) map[string]any {
+	merged := make(map[string]any, len(base)+len(override))
+	for key, value := range base {
+		merged[key] = value
+	}
+
+	for key, value := range override {
+		baseMap, baseIsMap := merged[key].(map[string]any)
+		overrideMap, overrideIsMap := value.(map[string]any)
+		if baseIsMap && overrideIsMap {
+			merged[key] = mergeMaps(baseMap, overrideMap)
+			continue
+		}
+		merged[key] = value
+	}
+
+	return merged
+}
+
+// balancerDecodeHook дополняет стандартные хуки viper преобразованием строки в BackendConfig,
+// чтобы старый формат списка бэкендов (просто URL) продолжал работать.
+func balancerDecodeHook() mapstructure.DecodeHookFunc {
+	return mapstructure.ComposeDecodeHookFunc(
+		mapstructure.StringToTimeDurationHookFunc(),
+		mapstructure.StringToSliceHookFunc(","),
+		stringToBackendConfigHookFunc(),
+	)
+}
+
+func stringToBackendConfigHookFunc() mapstructure.DecodeHookFuncType {
+	return func(f reflect.Type, t reflect.Type, data any) (any, error) {
+		if f.Kind() != reflect.String || t != reflect.TypeOf(BackendConfig{}) {
+			return data, nil
+		}
+
+		return BackendConfig{URL: data.(string), Weight: 1}, nil
+	}
+}
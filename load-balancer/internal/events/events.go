@@ -0,0 +1,100 @@
+// Package events реализует шину событий о смене состояния бэкендов.
+//
+// Основные возможности пакета:
+//   - Определяет типизированное событие смены состояния (up -> down, down -> up) с указанием причины.
+//   - Реализует внутрипроцессную шину (Bus) с подпиской через буферизированные каналы.
+//   - Предоставляет WebhookSink, который отправляет события POST-запросом в формате JSON с повторами.
+package events
+
+import (
+	"log"
+	"sync"
+	"time"
+)
+
+// State - состояние бэкенда.
+type State string
+
+const (
+	StateUp   State = "up"
+	StateDown State = "down"
+)
+
+// Reason - причина смены состояния бэкенда.
+type Reason string
+
+const (
+	ReasonHealthCheck  Reason = "health_check"  // результат активной проверки состояния
+	ReasonProxyError   Reason = "proxy_error"   // ошибка при проксировании запроса
+	ReasonProxySuccess Reason = "proxy_success" // успешный ответ на проксированный запрос
+	ReasonAdmin        Reason = "admin"         // действие администратора
+)
+
+// Event описывает смену состояния бэкенда.
+type Event struct {
+	Backend string    `json:"backend"`
+	From    State     `json:"from"`
+	To      State     `json:"to"`
+	Reason  Reason    `json:"reason"`
+	Error   string    `json:"error,omitempty"`
+	Time    time.Time `json:"time"`
+}
+
+// Bus рассылает события всем подписчикам. Публикация не блокируется:
+// если буфер подписчика заполнен, событие для него отбрасывается.
+// Нулевой указатель на Bus допустим - публикация в него ничего не делает.
+type Bus struct {
+	mu     sync.RWMutex
+	nextID int
+	subs   map[int]chan Event
+}
+
+func NewBus() *Bus {
+	return &Bus{
+		subs: make(map[int]chan Event),
+	}
+}
+
+// Subscribe создает подписку с буфером заданного размера.
+// Возвращает канал событий и функцию отмены подписки, которая закрывает канал.
+func (b *Bus) Subscribe(buffer int) (<-chan Event, func()) {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+
+	id := b.nextID
+	b.nextID++
+
+	ch := make(chan Event, buffer)
+	b.subs[id] = ch
+
+	var once sync.Once
+	unsubscribe := func() {
+		once.Do(func() {
+			b.mu.Lock()
+			defer b.mu.Unlock()
+
+			delete(b.subs, id)
+			close(ch)
+		})
+	}
+
+	return ch, unsubscribe
+}
+
+// Publish отправляет событие всем подписчикам.
+func (b *Bus) Publish(e Event) {
+	if b == nil {
+		return
+	}
+
+	b.mu.RLock()
+	defer b.mu.RUnlock()
+
+	for _, ch := range b.subs {
+		select {
+		case ch <- e:
+		default:
+			log.Printf("[EVENTS] Subscriber buffer is full, dropping event for %s\n", e.Backend)
+		}
+	}
+}
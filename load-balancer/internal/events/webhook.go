@@ -0,0 +1,125 @@
+package events
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"fmt"
+	"log"
+	"net/http"
+	"time"
+
+	"github.com/mirskow/load-balancer/internal/config"
+)
+
+const (
+	defaultWebhookTimeout      = 5 * time.Second
+	defaultWebhookMaxRetries   = 3
+	defaultWebhookRetryBackoff = time.Second
+)
+
+// WebhookBuffer - рекомендуемый размер буфера подписки для WebhookSink.
+const WebhookBuffer = 100
+
+// WebhookSink отправляет события POST-запросом с телом в формате JSON на заданный URL.
+// При ошибке или ответе не 2xx запрос повторяется с экспоненциально растущей паузой.
+type WebhookSink struct {
+	url          string
+	headers      http.Header
+	client       *http.Client
+	maxRetries   int
+	retryBackoff time.Duration
+}
+
+func NewWebhookSink(cfg config.WebhookConfig) *WebhookSink {
+	s := &WebhookSink{
+		url:          cfg.URL,
+		headers:      make(http.Header, len(cfg.Headers)),
+		client:       &http.Client{Timeout: cfg.Timeout},
+		maxRetries:   cfg.MaxRetries,
+		retryBackoff: cfg.RetryBackoff,
+	}
+
+	if s.client.Timeout <= 0 {
+		s.client.Timeout = defaultWebhookTimeout
+	}
+	if s.maxRetries <= 0 {
+		s.maxRetries = defaultWebhookMaxRetries
+	}
+	if s.retryBackoff <= 0 {
+		s.retryBackoff = defaultWebhookRetryBackoff
+	}
+
+	for name, value := range cfg.Headers {
+		s.headers.Set(name, value)
+	}
+
+	return s
+}
+
+// Run отправляет события из канала подписки до завершения контекста или закрытия канала.
+// Подписку создает вызывающий код до запуска Run, чтобы не потерять события, опубликованные
+// сразу после старта.
+func (s *WebhookSink) Run(ctx context.Context, events <-chan Event) {
+	for {
+		select {
+		case <-ctx.Done():
+			log.Println("[EVENTS] Webhook sink stopped")
+			return
+		case e, ok := <-events:
+			if !ok {
+				log.Println("[EVENTS] Webhook sink stopped: subscription closed")
+				return
+			}
+
+			if err := s.deliver(ctx, e); err != nil {
+				log.Printf("[EVENTS] Webhook delivery failed for %s: %v\n", e.Backend, err)
+			}
+		}
+	}
+}
+
+// deliver отправляет событие, повторяя попытки до maxRetries раз.
+func (s *WebhookSink) deliver(ctx context.Context, e Event) error {
+	body, err := json.Marshal(e)
+	if err != nil {
+		return err
+	}
+
+	backoff := s.retryBackoff
+	for attempt := 0; ; attempt++ {
+		err = s.post(ctx, body)
+		if err == nil || attempt >= s.maxRetries {
+			return err
+		}
+
+		select {
+		case <-ctx.Done():
+			return ctx.Err()
+		case <-time.After(backoff):
+			backoff *= 2
+		}
+	}
+}
+
+func (s *WebhookSink) post(ctx context.Context, body []byte) error {
+	req, err := http.NewRequestWithContext(ctx, http.MethodPost, s.url, bytes.NewReader(body))
+	if err != nil {
+		return err
+	}
+
+	req.Header = s.headers.Clone()
+	req.Header.Set("Content-Type", "application/json")
+
+	resp, err := s.client.Do(req)
+	if err != nil {
+		return err
+	}
+	resp.Body.Close()
+
+	if resp.StatusCode < 200 || resp.StatusCode > 299 {
+		return fmt.Errorf("unexpected status %d", resp.StatusCode)
+	}
+
+	return nil
+}
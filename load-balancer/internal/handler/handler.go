@@ -3,6 +3,7 @@
 // и маршрутизацию запросов через балансировщик нагрузки.
 //
 // Основные функции пакета:
+//   - Присвоение запросу идентификатора (X-Request-ID) и его возврат клиенту.
 //   - Извлечение IP-адреса клиента из запроса.
 //   - Проверка лимита запросов с помощью сервиса RateLimiter.
 //   - Передача запроса на обработку балансировщику нагрузки (LoadBalancer).
@@ -15,29 +16,37 @@ import (
 	"net"
 	"net/http"
 
+	"github.com/mirskow/load-balancer/internal/config"
+	"github.com/mirskow/load-balancer/internal/requestid"
 	"github.com/mirskow/load-balancer/internal/services"
 )
 
 type Handler struct {
-	services *services.Services
+	services  *services.Services
+	requestID bool
 }
 
-func NewHandler(services *services.Services) *Handler {
+func NewHandler(services *services.Services, forwarding config.ForwardingConfig) *Handler {
 	return &Handler{
-		services: services,
+		services:  services,
+		requestID: forwarding.RequestID,
 	}
 }
 
 func (h *Handler) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	if h.requestID {
+		r = withRequestID(w, r)
+	}
+
 	clientIP, err := getClientIP(r.RemoteAddr)
 	if err != nil {
-		log.Printf("[HANDLER] Error parsing remoteAddr: %s", err)
+		log.Printf("[HANDLER] Error parsing remoteAddr: %s%s", err, requestid.Tag(r.Context()))
 		writeJSON(w, http.StatusInternalServerError, "internal server error")
 		return
 	}
 
 	if !h.services.RateLimiter.Allow(r.Context(), clientIP) {
-		log.Printf("[RATE - LIMITER] Too many request from client: %s\n", clientIP)
+		log.Printf("[RATE-LIMITER] Too many request from client: %s%s\n", clientIP, requestid.Tag(r.Context()))
 		writeJSON(w, http.StatusTooManyRequests, "Too many request from your IP")
 		return
 	}
@@ -45,6 +54,19 @@ func (h *Handler) ServeHTTP(w http.ResponseWriter, r *http.Request) {
 	h.services.LoadBalancer.Route(w, r)
 }
 
+// withRequestID берет идентификатор запроса из X-Request-ID клиента или генерирует новый,
+// возвращает его клиенту в ответе и сохраняет в контексте запроса.
+func withRequestID(w http.ResponseWriter, r *http.Request) *http.Request {
+	id := r.Header.Get(requestid.Header)
+	if !requestid.Valid(id) {
+		id = requestid.New()
+	}
+
+	w.Header().Set(requestid.Header, id)
+
+	return r.WithContext(requestid.NewContext(r.Context(), id))
+}
+
 func getClientIP(remoteAddr string) (string, error) {
 	host, _, err := net.SplitHostPort(remoteAddr)
 	if err != nil {
@@ -0,0 +1,58 @@
+// Package requestid генерирует идентификаторы запросов и передает их через контекст,
+// чтобы ответ клиенту, запрос к бэкенду и строки лога можно было сопоставить друг с другом.
+package requestid
+
+import (
+	"context"
+	"crypto/rand"
+	"encoding/hex"
+)
+
+// Header - заголовок, в котором передается идентификатор запроса.
+const Header = "X-Request-ID"
+
+// maxLength - максимальная длина идентификатора, присланного клиентом.
+const maxLength = 128
+
+type ctxKey struct{}
+
+// New генерирует случайный идентификатор запроса.
+func New() string {
+	var b [16]byte
+	_, _ = rand.Read(b[:])
+	return hex.EncodeToString(b[:])
+}
+
+// Valid сообщает, можно ли использовать присланный клиентом идентификатор: он не пустой,
+// не длиннее maxLength и состоит только из печатных ASCII-символов (чтобы не испортить строки лога).
+func Valid(id string) bool {
+	if id == "" || len(id) > maxLength {
+		return false
+	}
+	for i := 0; i < len(id); i++ {
+		if id[i] <= ' ' || id[i] > '~' {
+			return false
+		}
+	}
+	return true
+}
+
+// NewContext возвращает копию ctx с идентификатором запроса.
+func NewContext(ctx context.Context, id string) context.Context {
+	return context.WithValue(ctx, ctxKey{}, id)
+}
+
+// FromContext возвращает идентификатор запроса из контекста или пустую строку.
+func FromContext(ctx context.Context) string {
+	id, _ := ctx.Value(ctxKey{}).(string)
+	return id
+}
+
+// Tag возвращает суффикс для строки лога вида " request_id=<id>" или пустую строку,
+// если у запроса нет идентификатора.
+func Tag(ctx context.Context) string {
+	if id := FromContext(ctx); id != "" {
+		return " request_id=" + id
+	}
+	return ""
+}
@@ -3,65 +3,193 @@
 //
 // Основные возможности пакета:
 //   - Инкапсулирует пул бэкендов (serverPool) и стратегию выбора следующего бэкенда (BalancingStrategy).
-//   - Поддерживает автоматическую проверку состояния бэкендов (health check) с заданным интервалом.
-//   - Использует ReverseProxy для прозрачной передачи запросов на выбранный бэкенд.
+//   - Поддерживает автоматическую проверку состояния бэкендов (health check) с заданным интервалом,
+//     путем, методом, заголовками, допустимыми кодами ответа и проверкой тела ответа.
+//     Проверки выполняются параллельно ограниченным числом воркеров, с таймаутом и случайной задержкой.
+//     Помимо HTTP поддерживаются проверки TCP-соединением и по протоколу grpc.health.v1.
+//   - Использует ReverseProxy для прозрачной передачи запросов на выбранный бэкенд
+//     через настраиваемый транспорт (таймауты, пул соединений, HTTP/2 и h2c).
 //   - Автоматически помечает бэкенд как "нерабочий" при ошибках проксирования.
+//   - Меняет состояние бэкенда только после заданного числа ошибок или успехов подряд.
+//   - Временно извлекает из ротации бэкенды, которые отвечают ошибками 5xx (outlier detection).
+//   - Плавно возвращает восстановившиеся бэкенды в ротацию (slow start).
+//   - Защищает каждый бэкенд автоматическим выключателем (circuit breaker) с полуоткрытым состоянием.
+//   - Повторяет идемпотентные запросы на другом бэкенде при ошибке проксирования.
+//   - Дублирует медленные безопасные запросы на другой бэкенд (hedging) и отдает первый ответ.
+//   - Добавляет в запросы к бэкендам заголовки X-Forwarded-*, Forwarded (RFC 7239) и X-Request-ID.
+//   - Учитывает соединения после смены протокола (WebSocket) и закрывает простаивающие
+//     и ведущие к нерабочим бэкендам соединения.
+//   - Поддерживает привязку клиента к бэкенду (sticky sessions) через подписанную cookie.
+//   - Направляет запросы в именованные пулы бэкендов по хосту, пути, методу, заголовкам,
+//     параметрам запроса и cookie (Router), с преобразованием пути и заголовков на маршруте
+//     и разделением трафика между пулами по весам (canary).
 //   - Позволяет гибко расширять стратегии балансировки (например, Round Robin, Least Connections и др.).
 package balancer
 
 import (
 	"context"
+	"errors"
+	"fmt"
+	"io"
 	"log"
+	"math/rand/v2"
 	"net/http"
 	"net/http/httputil"
 	"net/url"
+	"strings"
+	"sync"
+	"sync/atomic"
 	"time"
 
 	"github.com/mirskow/load-balancer/internal/backends"
 	"github.com/mirskow/load-balancer/internal/config"
+	"github.com/mirskow/load-balancer/internal/events"
+	"github.com/mirskow/load-balancer/internal/requestid"
 )
 
 type ctxKey string
 
-const backendKey ctxKey = "backend"
+const attemptKey ctxKey = "attempt"
+
+// defaultHealthCheckConcurrency - максимальное число одновременных проверок по умолчанию.
+const defaultHealthCheckConcurrency = 10
 
 // BalancingStrategy определяет интерфейс для стратегий выбора следующего бэкенда.
 type BalancingStrategy interface {
 	NextBackend([]*backends.Backend) *backends.Backend
 }
 
+// RequestAwareStrategy определяет интерфейс для стратегий, которым для выбора бэкенда
+// нужен сам запрос (например, хеширование по IP клиента, заголовку или cookie).
+type RequestAwareStrategy interface {
+	BalancingStrategy
+	NextBackendForRequest(*http.Request, []*backends.Backend) *backends.Backend
+}
+
+// ExcludingStrategy определяет интерфейс для стратегий, которые строят таблицу по набору бэкендов
+// (консистентное хеширование) и умеют выбрать запасной бэкенд для ключа, не сокращая этот набор.
+// Сокращенный список бэкендов привел бы к перестроению таблицы при каждом пропуске бэкенда.
+type ExcludingStrategy interface {
+	RequestAwareStrategy
+	NextBackendExcluding(r *http.Request, pool []*backends.Backend, excluded func(*backends.Backend) bool) *backends.Backend
+}
+
+// attempt хранит результат одной попытки проксирования запроса на бэкенд.
+// Заполняется в ModifyResponse и ErrorHandler reverse proxy.
+type attempt struct {
+	backend   *backends.Backend
+	retryable bool          // при ошибке запрос будет повторен, и ErrorHandler не должен отвечать клиенту
+	status    int           // код ответа бэкенда, 0 - если ответа не было
+	err       error         // ошибка проксирования
+	latency   time.Duration // время проксирования
+
+	headerTimer    *time.Timer // таймер ожидания заголовков ответа, nil - если таймаута нет
+	headerTimedOut atomic.Bool // попытка отменена, потому что заголовки не пришли вовремя
+}
+
+// failed сообщает, считается ли попытка неудачной (ошибка проксирования или ответ 5xx).
+func (a *attempt) failed() bool {
+	return a.err != nil || a.status >= http.StatusInternalServerError
+}
+
 type LoadBalancer struct {
-	serverPool []*backends.Backend
-	strategy   BalancingStrategy
+	serverPool   []*backends.Backend
+	strategy     BalancingStrategy
+	sticky       *stickySessions // nil, если привязка к бэкенду отключена
+	healthChecks map[*backends.Backend]HealthChecker
+	outliers     *outlierDetector                      // nil, если обнаружение выбросов отключено
+	breakers     map[*backends.Backend]*circuitBreaker // nil, если выключатели отключены
+	retry        *retryPolicy                          // nil, если повторы отключены
+	hedging      *hedgingPolicy                        // nil, если дублирование запросов отключено
+	transport    *upstreamTransport
+	forwarding   forwardingHeaders
+	upgrades     *upgradeTracker
 }
 
 // NewLoadBalancer создает новый балансировщик нагрузки с заданной конфигурацией и стратегией.
-// Запускает цикл health check для проверки состояния бэкендов
-func NewLoadBalancer(ctx context.Context, cfg config.BalancerConfig) *LoadBalancer {
-	const startIndex uint64 = 1
+// События о смене состояния бэкендов публикуются в bus (может быть nil).
+// Запускает цикл health check для проверки состояния бэкендов.
+// Возвращает ошибку, если стратегия из конфигурации неизвестна или настроена неверно.
+func NewLoadBalancer(ctx context.Context, cfg config.BalancerConfig, bus *events.Bus) (*LoadBalancer, error) {
+	strategy, err := NewStrategy(cfg)
+	if err != nil {
+		return nil, err
+	}
 
-	backendList := make([]*backends.Backend, 0, len(cfg.Backends))
+	lb := &LoadBalancer{
+		serverPool:   make([]*backends.Backend, 0, len(cfg.Backends)),
+		strategy:     strategy,
+		healthChecks: make(map[*backends.Backend]HealthChecker, len(cfg.Backends)),
+		transport:    newUpstreamTransport(cfg.Transport),
+		forwarding:   forwardingHeaders{cfg: cfg.Forwarding},
+	}
 
-	for _, addr := range cfg.Backends {
-		serverURL, err := url.Parse(addr)
+	for _, backendCfg := range cfg.Backends {
+		serverURL, err := url.Parse(backendCfg.URL)
 		if err != nil {
-			log.Printf("[BALANCER] Error parsing backend url - %s: %v", addr, err)
+			log.Printf("[BALANCER] Error parsing backend url - %s: %v", backendCfg.URL, err)
 			continue
 		}
 
-		proxy := createReverseProxy(serverURL)
+		healthCheckCfg := mergeHealthCheckConfig(cfg.HealthCheck, backendCfg.HealthCheck)
+
+		healthCheck, err := NewHealthChecker(healthCheckCfg)
+		if err != nil {
+			return nil, fmt.Errorf("backend %s: %w", backendCfg.URL, err)
+		}
+
+		proxy := lb.createReverseProxy(serverURL)
+		backend := backends.NewBackend(serverURL, backendCfg.Weight, proxy)
+		backend.SetThresholds(healthCheckCfg.HealthyThreshold, healthCheckCfg.UnhealthyThreshold)
+		backend.SetEventBus(bus)
+		backend.SetSlowStart(cfg.SlowStart.Window, float64(cfg.SlowStart.MinWeightPercent)/100)
 
-		backendList = append(backendList, backends.NewBackend(serverURL, proxy))
+		lb.serverPool = append(lb.serverPool, backend)
+		lb.healthChecks[backend] = healthCheck
 	}
 
-	lb := &LoadBalancer{
-		serverPool: backendList,
-		strategy:   NewRoundRobin(uint64(startIndex)),
+	if cfg.StickySession.Enabled {
+		lb.sticky, err = newStickySessions(cfg.StickySession, lb.serverPool)
+		if err != nil {
+			return nil, err
+		}
 	}
 
-	go lb.healthCheckLoop(ctx, cfg.HealthCheckTime)
+	if cfg.Retry.Enabled {
+		lb.retry, err = newRetryPolicy(cfg.Retry)
+		if err != nil {
+			return nil, err
+		}
+	}
+
+	if cfg.Hedging.Enabled {
+		lb.hedging, err = newHedgingPolicy(cfg.Hedging)
+		if err != nil {
+			return nil, err
+		}
+	}
+
+	if cfg.CircuitBreaker.Enabled {
+		lb.breakers = make(map[*backends.Backend]*circuitBreaker, len(lb.serverPool))
+		for _, b := range lb.serverPool {
+			breaker, err := newCircuitBreaker(b.URL.String(), cfg.CircuitBreaker)
+			if err != nil {
+				return nil, err
+			}
+			lb.breakers[b] = breaker
+		}
+	}
 
-	return lb
+	if cfg.OutlierDetection.Enabled {
+		lb.outliers = newOutlierDetector(cfg.OutlierDetection, lb.serverPool)
+		go lb.outliers.run(ctx)
+	}
+
+	lb.upgrades = newUpgradeTracker(cfg.Upgrade, lb.drainReason)
+	go lb.upgrades.run(ctx)
+	go lb.healthCheckLoop(ctx, cfg.HealthCheckTime, cfg.HealthCheckJitter, cfg.HealthCheckConcurrency)
+
+	return lb, nil
 }
 
 // Route выбирает живой бэкенд и согласно стратегии проксирует запрос к нему.
@@ -69,91 +197,405 @@ func NewLoadBalancer(ctx context.Context, cfg config.BalancerConfig) *LoadBalanc
 func (lb *LoadBalancer) Route(w http.ResponseWriter, r *http.Request) {
 	aliveBackends := lb.getAliveBackends()
 	if len(aliveBackends) == 0 {
-		lb.respondNoBackends(w)
+		lb.respondNoBackends(w, r)
 		return
 	}
 
-	backend := lb.strategy.NextBackend(aliveBackends)
+	backend, pinned := lb.pickBackend(r, aliveBackends)
 	if backend == nil {
-		lb.respondNoBackends(w)
+		lb.respondNoBackends(w, r)
 		return
 	}
 
-	ctx := context.WithValue(r.Context(), backendKey, backend)
+	if lb.sticky != nil && !pinned {
+		lb.sticky.pin(w, backend)
+	}
+
+	if isUpgrade(r) {
+		clearDeadlines(w, r)
+	}
+
+	if lb.hedging != nil && lb.hedging.matches(r) {
+		lb.forwardHedged(w, r, backend)
+		return
+	}
+
+	if lb.retry != nil {
+		lb.forwardWithRetries(w, r, backend)
+		return
+	}
+
+	lb.forward(w, r, backend, false, 0)
+}
+
+// pickBackend выбирает бэкенд для запроса: сначала бэкенд, к которому привязан клиент,
+// затем бэкенд по стратегии. Бэкенды, чей выключатель не пропускает запрос, пропускаются.
+// Второе значение равно true, если бэкенд выбран по привязке клиента.
+func (lb *LoadBalancer) pickBackend(r *http.Request, aliveBackends []*backends.Backend) (*backends.Backend, bool) {
+	return lb.pickBackendExcluding(r, aliveBackends, nil)
+}
+
+// pickBackendExcluding выбирает бэкенд так же, как pickBackend, но не выбирает бэкенды из tried.
+func (lb *LoadBalancer) pickBackendExcluding(r *http.Request, aliveBackends, tried []*backends.Backend) (*backends.Backend, bool) {
+	excluded := make(map[*backends.Backend]bool, len(tried))
+	for _, b := range tried {
+		excluded[b] = true
+	}
+
+	if backend := lb.stickyBackend(r, aliveBackends); backend != nil && !excluded[backend] && lb.admit(backend) {
+		return backend, true
+	}
+
+	for range aliveBackends {
+		backend := lb.nextBackendExcluding(r, aliveBackends, excluded)
+		if backend == nil {
+			return nil, false
+		}
+		if lb.admit(backend) {
+			return backend, false
+		}
+
+		excluded[backend] = true
+	}
+
+	return nil, false
+}
+
+// forward проксирует запрос на бэкенд и учитывает результат попытки.
+// Если retryable = true, при ошибке проксирования ответ клиенту не записывается,
+// чтобы запрос можно было повторить на другом бэкенде.
+// Если headerTimeout > 0, попытка отменяется, когда бэкенд не прислал заголовки ответа за это время.
+// Передача тела ответа таймаутом не ограничена.
+func (lb *LoadBalancer) forward(w http.ResponseWriter, r *http.Request, backend *backends.Backend, retryable bool, headerTimeout time.Duration) *attempt {
+	at := &attempt{backend: backend, retryable: retryable}
+
+	ctx := context.WithValue(r.Context(), attemptKey, at)
+	// Таймаут не применяется к смене протокола: ответ 101 приходит сразу, а ждать его нечего ограничивать.
+	if headerTimeout > 0 && !isUpgrade(r) {
+		var cancel context.CancelFunc
+		ctx, cancel = context.WithCancel(ctx)
+		defer cancel()
+
+		at.headerTimer = time.AfterFunc(headerTimeout, func() {
+			at.headerTimedOut.Store(true)
+			cancel()
+		})
+		defer at.headerTimer.Stop()
+	}
 	r = r.WithContext(ctx)
 
-	log.Printf("[BALANCER] Forwarding request to: %s\n", backend.URL.String())
+	log.Printf("[BALANCER] Forwarding request to: %s%s\n", backend.URL.String(), requestid.Tag(ctx))
+
+	backend.IncActiveRequests()
+	defer backend.DecActiveRequests()
+
+	// Результат учитывается в defer: если прокси прервет запрос паникой (http.ErrAbortHandler),
+	// выключатель все равно получит результат и не потеряет пробный слот.
+	start := time.Now()
+	defer func() {
+		at.latency = time.Since(start)
+		lb.recordAttempt(at)
+	}()
+
 	backend.ReverseProxy.ServeHTTP(w, r)
+
+	return at
+}
+
+// recordAttempt учитывает завершенную попытку во времени ответа бэкенда и в его выключателе.
+func (lb *LoadBalancer) recordAttempt(at *attempt) {
+	breaker := lb.breakers[at.backend]
+
+	// Попытка отменена до получения ответа (клиентом или проигравшая дублирующая попытка) -
+	// ее время и результат ничего не говорят о бэкенде.
+	if at.status == 0 && at.err == nil {
+		if breaker != nil {
+			breaker.release()
+		}
+		return
+	}
+
+	// Время жизни соединения после смены протокола не является временем ответа бэкенда.
+	if at.status == http.StatusSwitchingProtocols {
+		if breaker != nil {
+			breaker.record(false, 0)
+		}
+		return
+	}
+
+	at.backend.ObserveLatency(at.latency)
+	if breaker != nil {
+		breaker.record(at.failed(), at.latency)
+	}
+}
+
+// admit спрашивает у выключателя бэкенда, можно ли отправить на него запрос.
+func (lb *LoadBalancer) admit(backend *backends.Backend) bool {
+	breaker := lb.breakers[backend]
+	return breaker == nil || breaker.allow()
 }
 
-func (lb *LoadBalancer) respondNoBackends(w http.ResponseWriter) {
+// stickyBackend возвращает бэкенд, к которому привязан клиент, если привязка включена и бэкенд жив.
+func (lb *LoadBalancer) stickyBackend(r *http.Request, aliveBackends []*backends.Backend) *backends.Backend {
+	if lb.sticky == nil {
+		return nil
+	}
+
+	return lb.sticky.backendFor(r, aliveBackends)
+}
+
+// nextBackendExcluding выбирает стратегией бэкенд, не попавший в excluded. Стратегиям ExcludingStrategy
+// передается полный список бэкендов, остальным - список без исключенных.
+func (lb *LoadBalancer) nextBackendExcluding(r *http.Request, aliveBackends []*backends.Backend, excluded map[*backends.Backend]bool) *backends.Backend {
+	if len(excluded) == 0 {
+		return lb.nextBackend(r, aliveBackends)
+	}
+
+	if strategy, ok := lb.strategy.(ExcludingStrategy); ok {
+		return strategy.NextBackendExcluding(r, aliveBackends, func(b *backends.Backend) bool { return excluded[b] })
+	}
+
+	rest := make([]*backends.Backend, 0, len(aliveBackends))
+	for _, b := range aliveBackends {
+		if !excluded[b] {
+			rest = append(rest, b)
+		}
+	}
+	if len(rest) == 0 {
+		return nil
+	}
+
+	return lb.nextBackend(r, rest)
+}
+
+// nextBackend выбирает бэкенд стратегией, передавая ей запрос, если стратегия это поддерживает.
+func (lb *LoadBalancer) nextBackend(r *http.Request, aliveBackends []*backends.Backend) *backends.Backend {
+	if strategy, ok := lb.strategy.(RequestAwareStrategy); ok {
+		return strategy.NextBackendForRequest(r, aliveBackends)
+	}
+
+	return lb.strategy.NextBackend(aliveBackends)
+}
+
+func (lb *LoadBalancer) respondNoBackends(w http.ResponseWriter, r *http.Request) {
 	http.Error(w, "Service unavailable: no alive backend", http.StatusServiceUnavailable)
-	log.Println("[BALANCER] No alive backends available")
+	log.Printf("[BALANCER] No alive backends available%s\n", requestid.Tag(r.Context()))
 }
 
+// getAliveBackends возвращает живые и не исключенные бэкенды. Выключатели проверяются при выборе бэкенда,
+// а не здесь: иначе каждое открытие и закрытие выключателя меняло бы набор бэкендов и перестраивало
+// таблицы хеширующих стратегий.
 func (lb *LoadBalancer) getAliveBackends() []*backends.Backend {
 	alive := make([]*backends.Backend, 0, len(lb.serverPool))
 
 	for _, b := range lb.serverPool {
-		if b.IsAlive() {
-			alive = append(alive, b)
+		if !b.IsAlive() || b.IsEjected() {
+			continue
 		}
+
+		alive = append(alive, b)
 	}
 
 	return alive
 }
 
-func (lb *LoadBalancer) healthCheck() {
+// drainReason возвращает причину закрыть долгоживущие соединения с бэкендом или "", если бэкенд в ротации.
+func (lb *LoadBalancer) drainReason(b *backends.Backend) string {
+	switch {
+	case !b.IsAlive():
+		return "backend is down"
+	case b.IsEjected():
+		return "backend is ejected"
+	}
+
+	if breaker := lb.breakers[b]; breaker != nil && breaker.isOpen() {
+		return "circuit breaker is open"
+	}
+	return ""
+}
+
+// healthCheck проверяет все бэкенды пула параллельно, не более concurrency проверок одновременно.
+// Каждая проверка ограничена собственным таймаутом, поэтому зависший бэкенд не задерживает остальные.
+func (lb *LoadBalancer) healthCheck(ctx context.Context, concurrency int) {
+	sem := make(chan struct{}, concurrency)
+	var wg sync.WaitGroup
+
 	for _, b := range lb.serverPool {
 		if b == nil || b.URL == nil {
 			log.Printf("[BALANCER - HealthCheckError] nil backend or URL")
 			continue
 		}
 
-		resp, err := http.Get(b.URL.String())
-		alive := err == nil && resp != nil && resp.StatusCode == http.StatusOK
+		select {
+		case <-ctx.Done():
+			wg.Wait()
+			return
+		case sem <- struct{}{}:
+		}
 
-		b.SetAlive(alive)
+		wg.Add(1)
+		go func(b *backends.Backend) {
+			defer wg.Done()
+			defer func() { <-sem }()
 
-		if err != nil || resp == nil || resp.StatusCode != http.StatusOK {
-			code := 0
-			if resp != nil {
-				code = resp.StatusCode
-			}
-			log.Printf("[BALANCER - HealthCheckError] %s - %d : (%v)\n", b.URL, code, err)
+			lb.probe(ctx, b)
+		}(b)
+	}
+
+	wg.Wait()
+}
+
+// probe выполняет одну проверку бэкенда, сохраняет ее результат и обновляет состояние бэкенда.
+func (lb *LoadBalancer) probe(ctx context.Context, b *backends.Backend) {
+	start := time.Now()
+	err := lb.healthChecks[b].Check(ctx, b)
+
+	b.SetLastProbe(backends.ProbeResult{
+		Time:     start,
+		Duration: time.Since(start),
+		Err:      err,
+	})
+
+	if err == nil {
+		if b.ReportSuccess(events.ReasonHealthCheck) {
+			log.Printf("[BALANCER] Backend %s is UP\n", b.URL)
 		}
+		return
 	}
 
+	log.Printf("[BALANCER - HealthCheckError] %s : (%v)\n", b.URL, err)
+	if b.ReportFailure(events.ReasonHealthCheck, err) {
+		log.Printf("[BALANCER] Backend %s is DOWN\n", b.URL)
+	}
 }
 
-// healthCheckLoop запускает периодическую проверку состояния бэкендов до завершения контекста
-func (lb *LoadBalancer) healthCheckLoop(ctx context.Context, healthCheckTime time.Duration) {
-	t := time.NewTicker(time.Second * healthCheckTime)
+// healthCheckLoop запускает периодическую проверку состояния бэкендов до завершения контекста.
+// К каждому интервалу добавляется случайная задержка до jitter, чтобы несколько экземпляров
+// балансировщика не проверяли бэкенды одновременно.
+func (lb *LoadBalancer) healthCheckLoop(ctx context.Context, healthCheckTime, jitter time.Duration, concurrency int) {
+	if concurrency <= 0 {
+		concurrency = defaultHealthCheckConcurrency
+	}
+
+	t := time.NewTimer(withJitter(time.Second*healthCheckTime, jitter))
 	defer t.Stop()
 
 	for {
 		select {
 		case <-ctx.Done():
+			lb.closeHealthChecks()
 			log.Println("[BALANCER] Health check loop stopped")
 			return
 		case <-t.C:
 			log.Println("[BALANCER] Starting health check...")
-			lb.healthCheck()
+			lb.healthCheck(ctx, concurrency)
 			log.Println("[BALANCER] Health check completed")
+
+			t.Reset(withJitter(time.Second*healthCheckTime, jitter))
+		}
+	}
+}
+
+// closeHealthChecks освобождает ресурсы проверок, которые держат соединения с бэкендами (gRPC-клиенты).
+func (lb *LoadBalancer) closeHealthChecks() {
+	for b, check := range lb.healthChecks {
+		if closer, ok := check.(io.Closer); ok {
+			if err := closer.Close(); err != nil {
+				log.Printf("[BALANCER] Error closing health check of %s: %v\n", b.URL, err)
+			}
 		}
 	}
 }
 
-// newReverseProxy создает reverse proxy для заданного backend URL с кастомным обработчиком ошибок.
-func createReverseProxy(serverURL *url.URL) *httputil.ReverseProxy {
+// withJitter добавляет к интервалу случайную задержку в диапазоне [0, jitter).
+func withJitter(interval, jitter time.Duration) time.Duration {
+	if jitter <= 0 {
+		return interval
+	}
+	return interval + rand.N(jitter)
+}
+
+// createReverseProxy создает reverse proxy для заданного backend URL с кастомным обработчиком ошибок.
+// Ответы и ошибки бэкенда учитываются в попытке проксирования, в состоянии бэкенда
+// и в обнаружении выбросов. Если включены выключатели, ошибка проксирования не меняет состояние
+// бэкенда напрямую - решение принимает выключатель.
+func (lb *LoadBalancer) createReverseProxy(serverURL *url.URL) *httputil.ReverseProxy {
 	proxy := httputil.NewSingleHostReverseProxy(serverURL)
+	proxy.Transport = lb.transport.forURL(serverURL)
+
+	director := proxy.Director
+	proxy.Director = func(req *http.Request) {
+		rewrite := rewriteFromContext(req.Context())
+		if rewrite != nil {
+			rewrite.rules.rewritePath(req)
+		}
+
+		director(req)
+		lb.forwarding.apply(req)
+
+		if rewrite != nil {
+			rewrite.rules.rewriteRequestHeaders(req)
+		}
+	}
+
+	proxy.ModifyResponse = func(resp *http.Response) error {
+		if at, ok := resp.Request.Context().Value(attemptKey).(*attempt); ok {
+			at.status = resp.StatusCode
+			if at.headerTimer != nil {
+				at.headerTimer.Stop()
+			}
+
+			// Идентификатор запроса уже отправлен клиенту балансировщиком, дубль от бэкенда не нужен.
+			if requestid.FromContext(resp.Request.Context()) != "" {
+				resp.Header.Del(requestid.Header)
+			}
+
+			// Ответ 5xx не считается успехом: его учитывает обнаружение выбросов.
+			if resp.StatusCode < http.StatusInternalServerError {
+				at.backend.ReportSuccess(events.ReasonProxySuccess)
+			}
+
+			if lb.outliers != nil {
+				lb.outliers.observeStatus(at.backend, resp.StatusCode)
+			}
+
+			if rewrite := rewriteFromContext(resp.Request.Context()); rewrite != nil {
+				rewrite.rewriteResponse(resp)
+			}
+
+			if resp.StatusCode == http.StatusSwitchingProtocols {
+				if conn, ok := resp.Body.(io.ReadWriteCloser); ok {
+					websocket := strings.EqualFold(resp.Header.Get("Upgrade"), "websocket")
+					resp.Body = lb.upgrades.track(at.backend, conn, websocket)
+				}
+			}
+		}
+		return nil
+	}
 
 	proxy.ErrorHandler = func(w http.ResponseWriter, r *http.Request, err error) {
-		backend, ok := r.Context().Value(backendKey).(*backends.Backend)
+		at, ok := r.Context().Value(attemptKey).(*attempt)
+		if ok && at.headerTimedOut.Load() {
+			err = errHeaderTimeout
+		}
 
-		if ok {
-			backend.SetAlive(false)
-			log.Printf("[BALANCER - ErrorHandler] Marked backend %s as DOWN: %v", backend.URL, err)
+		// Запрос, отмененный клиентом, не говорит ничего о состоянии бэкенда.
+		if ok && !errors.Is(err, context.Canceled) {
+			at.err = err
+			backend := at.backend
+
+			log.Printf("[BALANCER - ErrorHandler] Proxy error from backend %s: %v%s", backend.URL, err, requestid.Tag(r.Context()))
+			if lb.breakers == nil && backend.ReportFailure(events.ReasonProxyError, err) {
+				log.Printf("[BALANCER - ErrorHandler] Marked backend %s as DOWN%s", backend.URL, requestid.Tag(r.Context()))
+			}
+
+			if lb.outliers != nil {
+				lb.outliers.observeError(backend)
+			}
+
+			if at.retryable {
+				return
+			}
 		}
 
 		http.Error(w, "Backend unavailable", http.StatusServiceUnavailable)
@@ -0,0 +1,242 @@
+package balancer
+
+import (
+	"fmt"
+	"log"
+	"sync"
+	"time"
+
+	"github.com/mirskow/load-balancer/internal/config"
+)
+
+const (
+	defaultBreakerWindow              = 10 * time.Second
+	defaultBreakerMinRequests         = 20
+	defaultBreakerErrorRatePercent    = 50
+	defaultBreakerSlowCallRatePercent = 50
+	defaultBreakerOpenDuration        = 30 * time.Second
+	defaultBreakerHalfOpenRequests    = 5
+	breakerBuckets                    = 10
+
+	// minBreakerWindow - минимальное скользящее окно: окно делится на breakerBuckets корзин,
+	// и слишком короткое окно теряет смысл.
+	minBreakerWindow = time.Second
+)
+
+// breakerState - состояние автоматического выключателя.
+type breakerState int
+
+const (
+	breakerClosed   breakerState = iota // запросы проходят, ведется статистика
+	breakerOpen                         // запросы не проходят до истечения openDuration
+	breakerHalfOpen                     // проходит ограниченное число пробных запросов
+)
+
+func (s breakerState) String() string {
+	switch s {
+	case breakerClosed:
+		return "closed"
+	case breakerOpen:
+		return "open"
+	default:
+		return "half-open"
+	}
+}
+
+// breakerBucket - статистика запросов за часть скользящего окна.
+type breakerBucket struct {
+	start    time.Time
+	requests int
+	failures int
+	slow     int
+}
+
+// circuitBreaker реализует автоматический выключатель для одного бэкенда.
+//
+// В закрытом состоянии выключатель собирает статистику за скользящее окно. Если доля ошибок или
+// медленных ответов превышает порог, выключатель открывается, и бэкенд не получает запросов
+// в течение openDuration. Затем выключатель переходит в полуоткрытое состояние и пропускает
+// не больше halfOpenRequests пробных запросов: если все они успешны, выключатель закрывается,
+// а при первой ошибке снова открывается.
+type circuitBreaker struct {
+	name                string
+	window              time.Duration
+	minRequests         int
+	errorRatePercent    int
+	slowCallDuration    time.Duration
+	slowCallRatePercent int
+	openDuration        time.Duration
+	halfOpenRequests    int
+
+	mu                sync.Mutex
+	state             breakerState
+	buckets           [breakerBuckets]breakerBucket
+	openedAt          time.Time
+	halfOpenInFlight  int
+	halfOpenSuccesses int
+}
+
+func newCircuitBreaker(name string, cfg config.CircuitBreakerConfig) (*circuitBreaker, error) {
+	cb := &circuitBreaker{
+		name:                name,
+		window:              cfg.Window,
+		minRequests:         cfg.MinRequests,
+		errorRatePercent:    cfg.ErrorRatePercent,
+		slowCallDuration:    cfg.SlowCallDuration,
+		slowCallRatePercent: cfg.SlowCallRatePercent,
+		openDuration:        cfg.OpenDuration,
+		halfOpenRequests:    cfg.HalfOpenRequests,
+	}
+
+	if cb.window == 0 {
+		cb.window = defaultBreakerWindow
+	}
+	if cb.window < minBreakerWindow {
+		return nil, fmt.Errorf("circuit breaker window must be at least %s, got %s", minBreakerWindow, cb.window)
+	}
+	if cb.minRequests <= 0 {
+		cb.minRequests = defaultBreakerMinRequests
+	}
+	if cb.errorRatePercent <= 0 {
+		cb.errorRatePercent = defaultBreakerErrorRatePercent
+	}
+	if cb.slowCallRatePercent <= 0 {
+		cb.slowCallRatePercent = defaultBreakerSlowCallRatePercent
+	}
+	if cb.openDuration <= 0 {
+		cb.openDuration = defaultBreakerOpenDuration
+	}
+	if cb.halfOpenRequests <= 0 {
+		cb.halfOpenRequests = defaultBreakerHalfOpenRequests
+	}
+
+	return cb, nil
+}
+
+// isOpen сообщает, открыт ли выключатель.
+func (cb *circuitBreaker) isOpen() bool {
+	cb.mu.Lock()
+	defer cb.mu.Unlock()
+
+	return cb.currentState(time.Now()) == breakerOpen
+}
+
+// allow решает, пропустить ли запрос. В полуоткрытом состоянии резервирует пробный слот,
+// поэтому после каждого разрешенного запроса обязательно вызывается record.
+func (cb *circuitBreaker) allow() bool {
+	cb.mu.Lock()
+	defer cb.mu.Unlock()
+
+	switch cb.currentState(time.Now()) {
+	case breakerOpen:
+		return false
+	case breakerHalfOpen:
+		if cb.halfOpenInFlight >= cb.halfOpenRequests-cb.halfOpenSuccesses {
+			return false
+		}
+		cb.halfOpenInFlight++
+		return true
+	default:
+		return true
+	}
+}
+
+// record учитывает результат запроса, пропущенного выключателем.
+func (cb *circuitBreaker) record(failed bool, latency time.Duration) {
+	cb.mu.Lock()
+	defer cb.mu.Unlock()
+
+	now := time.Now()
+	slow := cb.slowCallDuration > 0 && latency >= cb.slowCallDuration
+
+	switch cb.currentState(now) {
+	case breakerHalfOpen:
+		cb.halfOpenInFlight = max(cb.halfOpenInFlight-1, 0)
+		if failed || slow {
+			cb.transition(breakerOpen, now)
+			return
+		}
+
+		cb.halfOpenSuccesses++
+		if cb.halfOpenSuccesses >= cb.halfOpenRequests {
+			cb.transition(breakerClosed, now)
+		}
+
+	case breakerClosed:
+		bucket := cb.bucket(now)
+		bucket.requests++
+		if failed {
+			bucket.failures++
+		}
+		if slow {
+			bucket.slow++
+		}
+
+		if cb.shouldOpen(now) {
+			cb.transition(breakerOpen, now)
+		}
+	}
+}
+
+// release освобождает пробный слот запроса, результат которого неизвестен (запрос отменен).
+func (cb *circuitBreaker) release() {
+	cb.mu.Lock()
+	defer cb.mu.Unlock()
+
+	if cb.state == breakerHalfOpen {
+		cb.halfOpenInFlight = max(cb.halfOpenInFlight-1, 0)
+	}
+}
+
+// currentState возвращает состояние, переводя открытый выключатель в полуоткрытый по истечении openDuration.
+func (cb *circuitBreaker) currentState(now time.Time) breakerState {
+	if cb.state == breakerOpen && now.Sub(cb.openedAt) >= cb.openDuration {
+		cb.transition(breakerHalfOpen, now)
+	}
+	return cb.state
+}
+
+func (cb *circuitBreaker) transition(to breakerState, now time.Time) {
+	log.Printf("[BALANCER - CircuitBreaker] %s: %s -> %s\n", cb.name, cb.state, to)
+
+	cb.state = to
+	cb.halfOpenInFlight = 0
+	cb.halfOpenSuccesses = 0
+
+	switch to {
+	case breakerOpen:
+		cb.openedAt = now
+	case breakerClosed:
+		cb.buckets = [breakerBuckets]breakerBucket{}
+	}
+}
+
+// bucket возвращает корзину скользящего окна для текущего момента, сбрасывая устаревшую.
+func (cb *circuitBreaker) bucket(now time.Time) *breakerBucket {
+	width := cb.window / breakerBuckets
+	start := now.Truncate(width)
+	b := &cb.buckets[(start.UnixNano()/int64(width))%breakerBuckets]
+
+	if !b.start.Equal(start) {
+		*b = breakerBucket{start: start}
+	}
+	return b
+}
+
+func (cb *circuitBreaker) shouldOpen(now time.Time) bool {
+	var requests, failures, slow int
+	for _, b := range cb.buckets {
+		if now.Sub(b.start) < cb.window {
+			requests += b.requests
+			failures += b.failures
+			slow += b.slow
+		}
+	}
+
+	if requests < cb.minRequests {
+		return false
+	}
+
+	return failures*100 >= cb.errorRatePercent*requests ||
+		(cb.slowCallDuration > 0 && slow*100 >= cb.slowCallRatePercent*requests)
+}
@@ -0,0 +1,185 @@
+// Реализация стратегии балансировки нагрузки Consistent Hashing (кольцо с виртуальными узлами).
+//
+// Стратегия привязывает ключ запроса (IP клиента, заголовок, cookie или путь) к бэкенду,
+// поэтому запросы одного пользователя попадают на один и тот же узел и используют его кеш.
+// Когда бэкенд выпадает из пула живых, на другие узлы переезжают только его ключи.
+//
+// Особенности реализации:
+//   - Каждый бэкенд представлен на кольце набором виртуальных узлов для равномерного распределения.
+//   - Кольцо строится по набору живых бэкендов и перестраивается только при его изменении.
+//     Если бэкенд ключа нужно пропустить (повтор запроса, открытый выключатель), выбирается
+//     следующий по кольцу, а само кольцо не меняется.
+//   - Готовое кольцо неизменяемо и публикуется атомарно, поэтому поиск не требует блокировок.
+//   - Если все бэкенды недоступны - возвращается nil.
+package balancer
+
+import (
+	"hash/crc32"
+	"net/http"
+	"sort"
+	"strconv"
+	"sync"
+	"sync/atomic"
+
+	"github.com/mirskow/load-balancer/internal/backends"
+)
+
+const defaultVirtualNodes = 100
+
+type ConsistentHash struct {
+	hashKey      HashKeyFunc
+	virtualNodes int
+
+	mu   sync.Mutex // сериализует перестроение кольца
+	ring atomic.Pointer[hashRing]
+}
+
+// hashRing - неизменяемое кольцо, построенное для конкретного набора бэкендов.
+type hashRing struct {
+	members []*backends.Backend
+	hashes  []uint32
+	owners  []*backends.Backend
+}
+
+// NewConsistentHash создает новый экземпляр ConsistentHash с заданной функцией ключа
+// и количеством виртуальных узлов на бэкенд (0 - значение по умолчанию).
+func NewConsistentHash(hashKey HashKeyFunc, virtualNodes int) *ConsistentHash {
+	if virtualNodes <= 0 {
+		virtualNodes = defaultVirtualNodes
+	}
+
+	return &ConsistentHash{
+		hashKey:      hashKey,
+		virtualNodes: virtualNodes,
+	}
+}
+
+// NextBackend выбирает бэкенд для пустого ключа. Используется, только если запрос недоступен.
+func (ch *ConsistentHash) NextBackend(pool []*backends.Backend) *backends.Backend {
+	return ch.lookup("", pool)
+}
+
+// NextBackendForRequest выбирает бэкенд, которому на кольце принадлежит ключ запроса.
+func (ch *ConsistentHash) NextBackendForRequest(r *http.Request, pool []*backends.Backend) *backends.Backend {
+	return ch.lookup(ch.hashKey(r), pool)
+}
+
+// NextBackendExcluding выбирает ближайший по кольцу от ключа запроса бэкенд, для которого excluded вернул false.
+// Кольцо строится по всему пулу, поэтому исключение бэкендов не вызывает его перестроения.
+func (ch *ConsistentHash) NextBackendExcluding(r *http.Request, pool []*backends.Backend, excluded func(*backends.Backend) bool) *backends.Backend {
+	ring := ch.ringFor(aliveOnly(pool))
+	if !hasCandidate(ring.members, excluded) {
+		return nil
+	}
+
+	start := ring.search(ch.hashKey(r))
+	for i := range ring.owners {
+		if owner := ring.owners[(start+i)%len(ring.owners)]; !excluded(owner) {
+			return owner
+		}
+	}
+
+	return nil
+}
+
+func (ch *ConsistentHash) lookup(key string, pool []*backends.Backend) *backends.Backend {
+	ring := ch.ringFor(aliveOnly(pool))
+	if len(ring.hashes) == 0 {
+		return nil
+	}
+
+	return ring.owners[ring.search(key)]
+}
+
+// search возвращает индекс первого виртуального узла кольца по часовой стрелке от хеша ключа.
+func (ring *hashRing) search(key string) int {
+	h := crc32.ChecksumIEEE([]byte(key))
+	i := sort.Search(len(ring.hashes), func(i int) bool { return ring.hashes[i] >= h })
+	if i == len(ring.hashes) {
+		i = 0
+	}
+
+	return i
+}
+
+// ringFor возвращает кольцо для заданного набора бэкендов, перестраивая его при необходимости.
+func (ch *ConsistentHash) ringFor(members []*backends.Backend) *hashRing {
+	if ring := ch.ring.Load(); ring != nil && sameBackends(ring.members, members) {
+		return ring
+	}
+
+	ch.mu.Lock()
+	defer ch.mu.Unlock()
+
+	if ring := ch.ring.Load(); ring != nil && sameBackends(ring.members, members) {
+		return ring
+	}
+
+	ring := ch.buildRing(members)
+	ch.ring.Store(ring)
+
+	return ring
+}
+
+func (ch *ConsistentHash) buildRing(members []*backends.Backend) *hashRing {
+	type point struct {
+		hash  uint32
+		owner *backends.Backend
+	}
+
+	points := make([]point, 0, len(members)*ch.virtualNodes)
+	for _, b := range members {
+		for i := 0; i < ch.virtualNodes; i++ {
+			h := crc32.ChecksumIEEE([]byte(strconv.Itoa(i) + "#" + b.URL.String()))
+			points = append(points, point{hash: h, owner: b})
+		}
+	}
+
+	sort.Slice(points, func(i, j int) bool { return points[i].hash < points[j].hash })
+
+	ring := &hashRing{
+		members: members,
+		hashes:  make([]uint32, len(points)),
+		owners:  make([]*backends.Backend, len(points)),
+	}
+	for i, p := range points {
+		ring.hashes[i] = p.hash
+		ring.owners[i] = p.owner
+	}
+
+	return ring
+}
+
+// aliveOnly возвращает живые бэкенды из переданного списка.
+func aliveOnly(pool []*backends.Backend) []*backends.Backend {
+	alive := make([]*backends.Backend, 0, len(pool))
+	for _, b := range pool {
+		if b.IsAlive() {
+			alive = append(alive, b)
+		}
+	}
+	return alive
+}
+
+// hasCandidate сообщает, есть ли среди бэкендов хотя бы один, не попавший в excluded.
+func hasCandidate(members []*backends.Backend, excluded func(*backends.Backend) bool) bool {
+	for _, b := range members {
+		if !excluded(b) {
+			return true
+		}
+	}
+	return false
+}
+
+// sameBackends сообщает, совпадают ли два набора бэкендов (с учетом порядка).
+func sameBackends(a, b []*backends.Backend) bool {
+	if len(a) != len(b) {
+		return false
+	}
+	for i := range a {
+		if a[i] != b[i] {
+			return false
+		}
+	}
+	return true
+}
@@ -0,0 +1,76 @@
+package balancer
+
+import (
+	"net"
+	"net/http"
+	"strings"
+
+	"github.com/mirskow/load-balancer/internal/config"
+	"github.com/mirskow/load-balancer/internal/requestid"
+)
+
+// forwardingHeaders добавляет в запрос к бэкенду заголовки с информацией о клиенте и исходном запросе.
+type forwardingHeaders struct {
+	cfg config.ForwardingConfig
+}
+
+// apply вызывается из Director reverse proxy для каждой попытки проксирования.
+// X-Forwarded-For дописывается самим ReverseProxy, поэтому при явно выключенной настройке заголовок
+// помечается как nil, чтобы ReverseProxy его не добавлял.
+func (f forwardingHeaders) apply(req *http.Request) {
+	proto := "http"
+	if req.TLS != nil {
+		proto = "https"
+	}
+
+	if f.cfg.XForwardedFor != nil && !*f.cfg.XForwardedFor {
+		req.Header["X-Forwarded-For"] = nil
+	}
+
+	if f.cfg.XForwardedProto {
+		req.Header.Set("X-Forwarded-Proto", proto)
+	}
+
+	if f.cfg.XForwardedHost {
+		req.Header.Set("X-Forwarded-Host", req.Host)
+	}
+
+	if f.cfg.Forwarded {
+		element := "for=" + forwardedNode(req.RemoteAddr) + ";host=" + quoteForwarded(req.Host) + ";proto=" + proto
+		if prior := req.Header.Values("Forwarded"); len(prior) > 0 {
+			element = strings.Join(prior, ", ") + ", " + element
+		}
+		req.Header.Set("Forwarded", element)
+	}
+
+	if id := requestid.FromContext(req.Context()); id != "" {
+		req.Header.Set(requestid.Header, id)
+	}
+}
+
+// forwardedNode форматирует адрес клиента для параметра for заголовка Forwarded (RFC 7239, раздел 6).
+// IPv6-адреса берутся в квадратные скобки и кавычки.
+func forwardedNode(remoteAddr string) string {
+	host, _, err := net.SplitHostPort(remoteAddr)
+	if err != nil {
+		return "unknown"
+	}
+	if strings.Contains(host, ":") {
+		return `"[` + host + `]"`
+	}
+	return host
+}
+
+// quoteForwarded берет значение в кавычки, если оно содержит символы, недопустимые в token (например, ':' в host:port).
+func quoteForwarded(value string) string {
+	for _, c := range value {
+		if !isTokenChar(c) {
+			return `"` + strings.NewReplacer(`\`, `\\`, `"`, `\"`).Replace(value) + `"`
+		}
+	}
+	return value
+}
+
+func isTokenChar(c rune) bool {
+	return c < 0x7f && c > ' ' && !strings.ContainsRune(`()<>@,;:\"/[]?={}`, c)
+}
@@ -0,0 +1,63 @@
+package balancer
+
+import (
+	"fmt"
+	"net"
+	"net/http"
+)
+
+// Источники ключа для хеширующих стратегий.
+const (
+	HashKeyIP     = "ip"     // IP-адрес клиента
+	HashKeyHeader = "header" // значение заголовка с заданным именем
+	HashKeyCookie = "cookie" // значение cookie с заданным именем
+	HashKeyPath   = "path"   // путь запроса
+)
+
+// HashKeyFunc извлекает из запроса ключ, по которому хеширующая стратегия выбирает бэкенд.
+type HashKeyFunc func(*http.Request) string
+
+// NewHashKeyFunc создает функцию извлечения ключа для заданного источника.
+// Для источников header и cookie обязательно имя. Если заголовка или cookie нет в запросе,
+// ключом становится IP-адрес клиента.
+func NewHashKeyFunc(source, name string) (HashKeyFunc, error) {
+	switch source {
+	case HashKeyIP, "":
+		return clientIPKey, nil
+	case HashKeyHeader:
+		if name == "" {
+			return nil, fmt.Errorf("hash key source %q requires a header name", source)
+		}
+		return func(r *http.Request) string {
+			if v := r.Header.Get(name); v != "" {
+				return v
+			}
+			return clientIPKey(r)
+		}, nil
+	case HashKeyCookie:
+		if name == "" {
+			return nil, fmt.Errorf("hash key source %q requires a cookie name", source)
+		}
+		return func(r *http.Request) string {
+			if c, err := r.Cookie(name); err == nil && c.Value != "" {
+				return c.Value
+			}
+			return clientIPKey(r)
+		}, nil
+	case HashKeyPath:
+		return func(r *http.Request) string {
+			return r.URL.Path
+		}, nil
+	default:
+		return nil, fmt.Errorf("unknown hash key source %q", source)
+	}
+}
+
+// clientIPKey возвращает IP-адрес клиента без порта.
+func clientIPKey(r *http.Request) string {
+	host, _, err := net.SplitHostPort(r.RemoteAddr)
+	if err != nil {
+		return r.RemoteAddr
+	}
+	return host
+}
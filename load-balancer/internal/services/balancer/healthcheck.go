@@ -0,0 +1,242 @@
+package balancer
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"regexp"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/mirskow/load-balancer/internal/backends"
+	"github.com/mirskow/load-balancer/internal/config"
+)
+
+const (
+	defaultHealthCheckTimeout = 2 * time.Second
+	maxHealthCheckBodyBytes   = 64 << 10
+)
+
+// statusRange - диапазон допустимых кодов ответа health check (включительно).
+type statusRange struct {
+	from, to int
+}
+
+// Типы проверок состояния для ключа healthCheck.type.
+const (
+	HealthCheckHTTP = "http"
+	HealthCheckTCP  = "tcp"
+	HealthCheckGRPC = "grpc"
+)
+
+// HealthChecker определяет интерфейс для активной проверки состояния бэкенда.
+// Check возвращает nil, если бэкенд здоров, и ошибку с описанием причины в противном случае.
+type HealthChecker interface {
+	Check(ctx context.Context, b *backends.Backend) error
+}
+
+// NewHealthChecker создает проверку состояния указанного в cfg.Type типа (по умолчанию http).
+func NewHealthChecker(cfg config.HealthCheckConfig) (HealthChecker, error) {
+	timeout := cfg.Timeout
+	if timeout <= 0 {
+		timeout = defaultHealthCheckTimeout
+	}
+
+	switch strings.ToLower(cfg.Type) {
+	case HealthCheckHTTP, "":
+		return NewHTTPChecker(cfg)
+	case HealthCheckTCP:
+		return NewTCPChecker(timeout), nil
+	case HealthCheckGRPC:
+		return NewGRPCChecker(cfg.GRPCService, timeout), nil
+	default:
+		return nil, fmt.Errorf("unknown health check type %q", cfg.Type)
+	}
+}
+
+// HTTPChecker проверяет бэкенд HTTP-запросом и сверяет код и тело ответа с ожидаемыми.
+type HTTPChecker struct {
+	client       *http.Client
+	method       string
+	path         string
+	host         string
+	headers      http.Header
+	statuses     []statusRange
+	bodyContains string
+	bodyRegex    *regexp.Regexp
+	timeout      time.Duration
+}
+
+// mergeHealthCheckConfig накладывает заданные (ненулевые) поля override на базовую конфигурацию.
+func mergeHealthCheckConfig(base, override config.HealthCheckConfig) config.HealthCheckConfig {
+	merged := base
+
+	if override.Type != "" {
+		merged.Type = override.Type
+	}
+	if override.GRPCService != "" {
+		merged.GRPCService = override.GRPCService
+	}
+	if override.Path != "" {
+		merged.Path = override.Path
+	}
+	if override.Method != "" {
+		merged.Method = override.Method
+	}
+	if override.Host != "" {
+		merged.Host = override.Host
+	}
+	if len(override.Headers) > 0 {
+		merged.Headers = override.Headers
+	}
+	if len(override.ExpectedStatuses) > 0 {
+		merged.ExpectedStatuses = override.ExpectedStatuses
+	}
+	if override.BodyContains != "" {
+		merged.BodyContains = override.BodyContains
+	}
+	if override.BodyRegex != "" {
+		merged.BodyRegex = override.BodyRegex
+	}
+	if override.Timeout > 0 {
+		merged.Timeout = override.Timeout
+	}
+	if override.HealthyThreshold > 0 {
+		merged.HealthyThreshold = override.HealthyThreshold
+	}
+	if override.UnhealthyThreshold > 0 {
+		merged.UnhealthyThreshold = override.UnhealthyThreshold
+	}
+
+	return merged
+}
+
+// NewHTTPChecker создает HTTP-проверку. По умолчанию выполняется GET корня бэкенда
+// и ожидается ответ 200.
+func NewHTTPChecker(cfg config.HealthCheckConfig) (*HTTPChecker, error) {
+	hc := &HTTPChecker{
+		client: &http.Client{
+			// Редиректы не выполняются: код 3xx проверяется как обычный ответ.
+			CheckRedirect: func(*http.Request, []*http.Request) error {
+				return http.ErrUseLastResponse
+			},
+		},
+		method:       strings.ToUpper(cfg.Method),
+		path:         cfg.Path,
+		host:         cfg.Host,
+		headers:      make(http.Header, len(cfg.Headers)),
+		bodyContains: cfg.BodyContains,
+		timeout:      cfg.Timeout,
+	}
+
+	if hc.method == "" {
+		hc.method = http.MethodGet
+	}
+	if hc.timeout <= 0 {
+		hc.timeout = defaultHealthCheckTimeout
+	}
+
+	for name, value := range cfg.Headers {
+		hc.headers.Set(name, value)
+	}
+
+	statuses := cfg.ExpectedStatuses
+	if len(statuses) == 0 {
+		statuses = []string{strconv.Itoa(http.StatusOK)}
+	}
+	for _, s := range statuses {
+		r, err := parseStatusRange(s)
+		if err != nil {
+			return nil, err
+		}
+		hc.statuses = append(hc.statuses, r)
+	}
+
+	if cfg.BodyRegex != "" {
+		re, err := regexp.Compile(cfg.BodyRegex)
+		if err != nil {
+			return nil, fmt.Errorf("health check body regex: %w", err)
+		}
+		hc.bodyRegex = re
+	}
+
+	return hc, nil
+}
+
+// parseStatusRange разбирает код ("200") или диапазон кодов ("200-399").
+func parseStatusRange(s string) (statusRange, error) {
+	from, to, isRange := strings.Cut(strings.TrimSpace(s), "-")
+
+	fromCode, err := strconv.Atoi(strings.TrimSpace(from))
+	if err != nil {
+		return statusRange{}, fmt.Errorf("invalid health check status %q", s)
+	}
+	if !isRange {
+		return statusRange{from: fromCode, to: fromCode}, nil
+	}
+
+	toCode, err := strconv.Atoi(strings.TrimSpace(to))
+	if err != nil || toCode < fromCode {
+		return statusRange{}, fmt.Errorf("invalid health check status range %q", s)
+	}
+
+	return statusRange{from: fromCode, to: toCode}, nil
+}
+
+// Check выполняет проверку бэкенда. Возвращает nil, если бэкенд здоров.
+func (hc *HTTPChecker) Check(ctx context.Context, b *backends.Backend) error {
+	ctx, cancel := context.WithTimeout(ctx, hc.timeout)
+	defer cancel()
+
+	req, err := http.NewRequestWithContext(ctx, hc.method, b.URL.JoinPath(hc.path).String(), nil)
+	if err != nil {
+		return err
+	}
+
+	req.Header = hc.headers.Clone()
+	if hc.host != "" {
+		req.Host = hc.host
+	}
+
+	resp, err := hc.client.Do(req)
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+
+	if !hc.statusAccepted(resp.StatusCode) {
+		io.Copy(io.Discard, io.LimitReader(resp.Body, maxHealthCheckBodyBytes))
+		return fmt.Errorf("unexpected status %d", resp.StatusCode)
+	}
+
+	if hc.bodyContains == "" && hc.bodyRegex == nil {
+		io.Copy(io.Discard, io.LimitReader(resp.Body, maxHealthCheckBodyBytes))
+		return nil
+	}
+
+	body, err := io.ReadAll(io.LimitReader(resp.Body, maxHealthCheckBodyBytes))
+	if err != nil {
+		return err
+	}
+
+	if hc.bodyContains != "" && !strings.Contains(string(body), hc.bodyContains) {
+		return errors.New("response body does not contain expected substring")
+	}
+	if hc.bodyRegex != nil && !hc.bodyRegex.Match(body) {
+		return errors.New("response body does not match expected regex")
+	}
+
+	return nil
+}
+
+func (hc *HTTPChecker) statusAccepted(code int) bool {
+	for _, r := range hc.statuses {
+		if code >= r.from && code <= r.to {
+			return true
+		}
+	}
+	return false
+}
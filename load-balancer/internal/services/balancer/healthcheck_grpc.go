@@ -0,0 +1,95 @@
+package balancer
+
+import (
+	"context"
+	"crypto/tls"
+	"errors"
+	"fmt"
+	"sync"
+	"time"
+
+	"github.com/mirskow/load-balancer/internal/backends"
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/credentials"
+	"google.golang.org/grpc/credentials/insecure"
+	healthpb "google.golang.org/grpc/health/grpc_health_v1"
+)
+
+// GRPCChecker проверяет бэкенд по стандартному протоколу grpc.health.v1.Health/Check.
+// Бэкенд здоров, если сервис отвечает статусом SERVING.
+// Клиент каждого бэкенда создается при первой проверке и переиспользуется, как транспорт HTTP-проверки:
+// соединение не устанавливается заново на каждую проверку. Close закрывает все клиенты.
+type GRPCChecker struct {
+	service string
+	timeout time.Duration
+
+	mu    sync.Mutex
+	conns map[*backends.Backend]*grpc.ClientConn
+}
+
+// NewGRPCChecker создает gRPC-проверку для заданного сервиса (пустое имя - состояние сервера в целом).
+func NewGRPCChecker(service string, timeout time.Duration) *GRPCChecker {
+	return &GRPCChecker{
+		service: service,
+		timeout: timeout,
+		conns:   make(map[*backends.Backend]*grpc.ClientConn),
+	}
+}
+
+// Check выполняет RPC Health/Check. Для бэкендов со схемой https используется TLS.
+func (c *GRPCChecker) Check(ctx context.Context, b *backends.Backend) error {
+	ctx, cancel := context.WithTimeout(ctx, c.timeout)
+	defer cancel()
+
+	conn, err := c.conn(b)
+	if err != nil {
+		return err
+	}
+
+	resp, err := healthpb.NewHealthClient(conn).Check(ctx, &healthpb.HealthCheckRequest{Service: c.service})
+	if err != nil {
+		return err
+	}
+
+	if resp.GetStatus() != healthpb.HealthCheckResponse_SERVING {
+		return fmt.Errorf("grpc health status %s", resp.GetStatus())
+	}
+
+	return nil
+}
+
+// Close закрывает клиенты всех бэкендов. После Close проверка создает клиент заново.
+func (c *GRPCChecker) Close() error {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	var errs []error
+	for b, conn := range c.conns {
+		errs = append(errs, conn.Close())
+		delete(c.conns, b)
+	}
+	return errors.Join(errs...)
+}
+
+// conn возвращает клиент бэкенда, создавая его при первом обращении.
+// Клиент сам восстанавливает соединение после разрыва.
+func (c *GRPCChecker) conn(b *backends.Backend) (*grpc.ClientConn, error) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	if conn, ok := c.conns[b]; ok {
+		return conn, nil
+	}
+
+	creds := insecure.NewCredentials()
+	if b.URL.Scheme == "https" {
+		creds = credentials.NewTLS(&tls.Config{ServerName: b.URL.Hostname()})
+	}
+
+	conn, err := grpc.NewClient(backendAddr(b), grpc.WithTransportCredentials(creds))
+	if err != nil {
+		return nil, err
+	}
+	c.conns[b] = conn
+	return conn, nil
+}
@@ -0,0 +1,49 @@
+package balancer
+
+import (
+	"context"
+	"net"
+	"time"
+
+	"github.com/mirskow/load-balancer/internal/backends"
+)
+
+// TCPChecker считает бэкенд здоровым, если к нему удается установить TCP-соединение.
+type TCPChecker struct {
+	timeout time.Duration
+}
+
+// NewTCPChecker создает проверку TCP-соединением с заданным таймаутом.
+func NewTCPChecker(timeout time.Duration) *TCPChecker {
+	return &TCPChecker{
+		timeout: timeout,
+	}
+}
+
+// Check устанавливает и сразу закрывает TCP-соединение с бэкендом.
+func (c *TCPChecker) Check(ctx context.Context, b *backends.Backend) error {
+	ctx, cancel := context.WithTimeout(ctx, c.timeout)
+	defer cancel()
+
+	var d net.Dialer
+	conn, err := d.DialContext(ctx, "tcp", backendAddr(b))
+	if err != nil {
+		return err
+	}
+
+	return conn.Close()
+}
+
+// backendAddr возвращает адрес host:port бэкенда, подставляя порт по схеме URL, если он не указан.
+func backendAddr(b *backends.Backend) string {
+	if b.URL.Port() != "" {
+		return b.URL.Host
+	}
+
+	port := "80"
+	if b.URL.Scheme == "https" {
+		port = "443"
+	}
+
+	return net.JoinHostPort(b.URL.Hostname(), port)
+}
@@ -0,0 +1,354 @@
+package balancer
+
+import (
+	"context"
+	"fmt"
+	"log"
+	"net/http"
+	"regexp"
+	"slices"
+	"sort"
+	"strings"
+	"sync"
+	"sync/atomic"
+	"time"
+
+	"github.com/mirskow/load-balancer/internal/backends"
+	"github.com/mirskow/load-balancer/internal/config"
+	"github.com/mirskow/load-balancer/internal/requestid"
+)
+
+const (
+	defaultHedgingDelay      = 100 * time.Millisecond
+	hedgingLatencySamples    = 512
+	hedgingMinSamples        = 20
+	hedgingPercentileRefresh = time.Second
+)
+
+// hedgingPolicy описывает, для каких запросов и через сколько отправляется дублирующий запрос.
+type hedgingPolicy struct {
+	path       *regexp.Regexp
+	methods    map[string]bool
+	delay      time.Duration
+	percentile int // если > 0, задержка равна перцентилю наблюдаемого времени ответа
+
+	mu          sync.Mutex
+	samples     []time.Duration // кольцевой буфер последних замеров времени ответа
+	next        int
+	cachedDelay atomic.Int64 // перцентиль в наносекундах, 0 - еще не вычислен
+	computedAt  time.Time
+}
+
+func newHedgingPolicy(cfg config.HedgingConfig) (*hedgingPolicy, error) {
+	path, err := regexp.Compile(cfg.PathPattern)
+	if err != nil {
+		return nil, fmt.Errorf("hedging path pattern: %w", err)
+	}
+
+	if cfg.DelayPercentile < 0 || cfg.DelayPercentile >= 100 {
+		return nil, fmt.Errorf("hedging delay percentile must be in [0, 100), got %d", cfg.DelayPercentile)
+	}
+
+	p := &hedgingPolicy{
+		path:       path,
+		methods:    map[string]bool{http.MethodGet: true, http.MethodHead: true},
+		delay:      cfg.Delay,
+		percentile: cfg.DelayPercentile,
+		samples:    make([]time.Duration, 0, hedgingLatencySamples),
+	}
+
+	if p.delay <= 0 {
+		p.delay = defaultHedgingDelay
+	}
+	if len(cfg.Methods) > 0 {
+		p.methods = make(map[string]bool, len(cfg.Methods))
+		for _, m := range cfg.Methods {
+			p.methods[strings.ToUpper(m)] = true
+		}
+	}
+
+	return p, nil
+}
+
+// matches сообщает, можно ли дублировать запрос: метод безопасный, путь подходит под шаблон,
+// у запроса нет тела и это не запрос на смену протокола.
+func (p *hedgingPolicy) matches(r *http.Request) bool {
+	return p.methods[r.Method] &&
+		(r.Body == nil || r.Body == http.NoBody || r.ContentLength == 0) &&
+		r.Header.Get("Upgrade") == "" &&
+		p.path.MatchString(r.URL.Path)
+}
+
+// hedgeDelay возвращает задержку перед отправкой дублирующего запроса.
+func (p *hedgingPolicy) hedgeDelay() time.Duration {
+	if p.percentile == 0 {
+		return p.delay
+	}
+
+	p.mu.Lock()
+	defer p.mu.Unlock()
+
+	if len(p.samples) < hedgingMinSamples {
+		return p.delay
+	}
+
+	if time.Since(p.computedAt) >= hedgingPercentileRefresh {
+		sorted := slices.Clone(p.samples)
+		sort.Slice(sorted, func(i, j int) bool { return sorted[i] < sorted[j] })
+
+		p.cachedDelay.Store(int64(sorted[len(sorted)*p.percentile/100]))
+		p.computedAt = time.Now()
+	}
+
+	return time.Duration(p.cachedDelay.Load())
+}
+
+// observe запоминает время до получения заголовков ответа успешной попытки.
+func (p *hedgingPolicy) observe(latency time.Duration) {
+	if p.percentile == 0 {
+		return
+	}
+
+	p.mu.Lock()
+	defer p.mu.Unlock()
+
+	if len(p.samples) < hedgingLatencySamples {
+		p.samples = append(p.samples, latency)
+		return
+	}
+
+	p.samples[p.next] = latency
+	p.next = (p.next + 1) % hedgingLatencySamples
+}
+
+// hedgeRace выбирает первую попытку, получившую заголовки ответа, и отменяет остальные.
+type hedgeRace struct {
+	w       http.ResponseWriter
+	sticky  *stickySessions   // nil, если привязка к бэкенду отключена
+	primary *backends.Backend // бэкенд первой попытки, к нему клиент уже привязан
+
+	mu      sync.Mutex
+	winner  *hedgeWriter
+	writers []*hedgeWriter
+}
+
+// claim делает hw победителем, если победитель еще не выбран, и отменяет остальные попытки.
+func (race *hedgeRace) claim(hw *hedgeWriter) bool {
+	race.mu.Lock()
+	defer race.mu.Unlock()
+
+	if race.winner != nil {
+		return race.winner == hw
+	}
+
+	race.winner = hw
+	for _, other := range race.writers {
+		if other != hw {
+			other.cancel()
+		}
+	}
+	return true
+}
+
+// informational передает клиенту промежуточный ответ 1xx попытки hw, если победитель еще не выбран
+// или это сама hw. Заголовки ответа клиенту после этого восстанавливаются.
+func (race *hedgeRace) informational(hw *hedgeWriter, statusCode int) {
+	race.mu.Lock()
+	defer race.mu.Unlock()
+
+	if race.winner != nil && race.winner != hw {
+		return
+	}
+
+	dst := race.w.Header()
+	saved := dst.Clone()
+	for name, values := range hw.header {
+		dst[name] = values
+	}
+	race.w.WriteHeader(statusCode)
+
+	clear(dst)
+	for name, values := range saved {
+		dst[name] = values
+	}
+}
+
+func (race *hedgeRace) hasWinner() bool {
+	race.mu.Lock()
+	defer race.mu.Unlock()
+
+	return race.winner != nil
+}
+
+// hedgeWriter - ResponseWriter одной попытки. Заголовки накапливаются отдельно, и только
+// победившая попытка пишет ответ клиенту. Ответ проигравшей попытки отбрасывается.
+type hedgeWriter struct {
+	race        *hedgeRace
+	backend     *backends.Backend
+	cancel      context.CancelFunc
+	header      http.Header
+	start       time.Time
+	headerAfter time.Duration // время до получения заголовков ответа, 0 - если их не было
+	wroteHeader bool
+	won         bool
+}
+
+func (hw *hedgeWriter) Header() http.Header {
+	return hw.header
+}
+
+func (hw *hedgeWriter) WriteHeader(statusCode int) {
+	if hw.wroteHeader {
+		return
+	}
+
+	// Промежуточные ответы 1xx (100 Continue, 103 Early Hints) не означают, что пришел ответ:
+	// они передаются клиенту, но не выигрывают гонку.
+	if statusCode >= 100 && statusCode < 200 && statusCode != http.StatusSwitchingProtocols {
+		hw.race.informational(hw, statusCode)
+		return
+	}
+
+	hw.wroteHeader = true
+	hw.headerAfter = time.Since(hw.start)
+
+	if !hw.race.claim(hw) {
+		return
+	}
+	hw.won = true
+
+	dst := hw.race.w.Header()
+	for name, values := range hw.header {
+		dst[name] = values
+	}
+
+	// Клиент привязывается к бэкенду, чей ответ он получил, как и при повторе на другом бэкенде.
+	if hw.race.sticky != nil && hw.backend != hw.race.primary {
+		hw.race.sticky.unpin(hw.race.w)
+		hw.race.sticky.pin(hw.race.w, hw.backend)
+	}
+
+	hw.race.w.WriteHeader(statusCode)
+}
+
+func (hw *hedgeWriter) Write(p []byte) (int, error) {
+	if !hw.wroteHeader {
+		hw.WriteHeader(http.StatusOK)
+	}
+	if !hw.won {
+		return len(p), nil
+	}
+	return hw.race.w.Write(p)
+}
+
+// FlushError позволяет reverse proxy сбрасывать буфер клиенту для потоковых ответов.
+func (hw *hedgeWriter) FlushError() error {
+	if !hw.won {
+		return nil
+	}
+	return http.NewResponseController(hw.race.w).Flush()
+}
+
+// forwardHedged отправляет запрос на бэкенд и, если за задержку hedgeDelay не пришли заголовки ответа,
+// отправляет копию запроса на другой бэкенд. Клиент получает ответ, пришедший первым,
+// а другая попытка отменяется, и при включенной привязке клиент привязывается к бэкенду победителя.
+// Если первая попытка завершилась ошибкой раньше, копия отправляется сразу.
+func (lb *LoadBalancer) forwardHedged(w http.ResponseWriter, r *http.Request, backend *backends.Backend) {
+	race := &hedgeRace{w: w, sticky: lb.sticky, primary: backend}
+	results := make(chan hedgeResult, 2)
+
+	launch := func(b *backends.Backend) {
+		ctx, cancel := context.WithCancel(r.Context())
+		hw := &hedgeWriter{race: race, backend: b, cancel: cancel, header: w.Header().Clone(), start: time.Now()}
+
+		race.mu.Lock()
+		race.writers = append(race.writers, hw)
+		race.mu.Unlock()
+
+		go func() {
+			defer cancel()
+			results <- lb.forwardHedgeAttempt(hw, r.WithContext(ctx), b)
+		}()
+	}
+
+	launch(backend)
+	launched, finished := 1, 0
+	tried := []*backends.Backend{backend}
+
+	timer := time.NewTimer(lb.hedging.hedgeDelay())
+	defer timer.Stop()
+	hedgeAfter := timer.C
+
+	hedge := func() {
+		hedgeAfter = nil
+		if race.hasWinner() {
+			return
+		}
+
+		next, _ := lb.pickBackendExcluding(r, lb.getAliveBackends(), tried)
+		if next == nil {
+			return
+		}
+
+		log.Printf("[BALANCER] Hedging request to %s%s\n", next.URL, requestid.Tag(r.Context()))
+		tried = append(tried, next)
+		launch(next)
+		launched++
+	}
+
+	var panicked any
+	for finished < launched {
+		select {
+		case <-hedgeAfter:
+			hedge()
+		case res := <-results:
+			finished++
+			if res.panicked != nil {
+				// Прерванная проигравшая попытка ничего не значит для клиента, остальные паники
+				// передаются обработчику, как если бы попытка выполнялась в нем.
+				if res.panicked != http.ErrAbortHandler || res.won {
+					panicked = res.panicked
+				}
+				continue
+			}
+
+			if res.at.err == nil && res.headerAfter > 0 {
+				lb.hedging.observe(res.headerAfter)
+			}
+			if res.at.err != nil && hedgeAfter != nil {
+				hedge()
+			}
+		}
+	}
+
+	if panicked != nil {
+		panic(panicked)
+	}
+
+	if !race.hasWinner() {
+		http.Error(w, "Backend unavailable", http.StatusServiceUnavailable)
+	}
+}
+
+// hedgeResult - итог одной попытки дублированного запроса.
+type hedgeResult struct {
+	at          *attempt
+	headerAfter time.Duration // время до получения заголовков ответа, 0 - если их не было
+	won         bool          // ответ попытки отправлен клиенту
+	panicked    any           // значение паники, если попытка прервана паникой
+}
+
+// forwardHedgeAttempt выполняет попытку в отдельной горутине. Reverse proxy прерывает запрос паникой
+// http.ErrAbortHandler (клиент отключился, попытка отменена после получения заголовков),
+// а net/http перехватывает панику только в горутине обработчика. Поэтому паника перехватывается здесь
+// и возвращается в результате попытки.
+func (lb *LoadBalancer) forwardHedgeAttempt(hw *hedgeWriter, r *http.Request, b *backends.Backend) (res hedgeResult) {
+	defer func() {
+		if v := recover(); v != nil {
+			res = hedgeResult{won: hw.won, panicked: v}
+		}
+	}()
+
+	at := lb.forward(hw, r, b, true, 0)
+	return hedgeResult{at: at, headerAfter: hw.headerAfter, won: hw.won}
+}
@@ -0,0 +1,58 @@
+// Реализация стратегии балансировки нагрузки Least Connections.
+//
+// Стратегия Least Connections направляет запрос на живой бэкенд с наименьшим количеством
+// запросов в обработке. Подходит для нагрузки, где время обработки запросов сильно различается:
+// бэкенды, занятые медленными запросами, не получают новую работу, пока не освободятся.
+//
+// Особенности реализации:
+//   - Количество запросов в обработке хранится в самом бэкенде (атомарный счетчик).
+//   - Бэкенд в режиме плавного ввода (slow start) считается пропорционально более загруженным.
+//   - При равной нагрузке выбор начинается со смещения, которое сдвигается атомарно,
+//     чтобы простаивающие бэкенды получали запросы поровну.
+//   - Если все бэкенды недоступны - возвращается nil.
+package balancer
+
+import (
+	"sync/atomic"
+
+	"github.com/mirskow/load-balancer/internal/backends"
+)
+
+type LeastConnections struct {
+	offset uint64
+}
+
+// NewLeastConnections создает новый экземпляр стратегии LeastConnections.
+func NewLeastConnections() *LeastConnections {
+	return &LeastConnections{}
+}
+
+// NextBackend выбирает живой бэкенд с наименьшим количеством запросов в обработке.
+func (lc *LeastConnections) NextBackend(pool []*backends.Backend) *backends.Backend {
+	countBackends := len(pool)
+	if countBackends == 0 {
+		return nil
+	}
+
+	start := int(atomic.AddUint64(&lc.offset, uint64(1)) % uint64(countBackends))
+
+	var (
+		best     *backends.Backend
+		bestLoad float64
+	)
+
+	for i := 0; i < countBackends; i++ {
+		b := pool[(start+i)%countBackends]
+		if !b.IsAlive() {
+			continue
+		}
+
+		load := slowStartLoad(b, float64(b.ActiveRequests()))
+		if best == nil || load < bestLoad {
+			best = b
+			bestLoad = load
+		}
+	}
+
+	return best
+}
@@ -0,0 +1,158 @@
+// Реализация стратегии балансировки нагрузки Maglev (консистентное хеширование Google).
+//
+// Стратегия строит таблицу поиска фиксированного размера (простое число), в которой каждая ячейка
+// принадлежит одному бэкенду. Ключ запроса хешируется в номер ячейки, поэтому поиск выполняется за O(1),
+// а доли бэкендов в таблице отличаются не более чем на одну ячейку. При выпадении бэкенда
+// переезжает лишь небольшая часть ключей.
+//
+// Особенности реализации:
+//   - Для каждого бэкенда вычисляется перестановка ячеек (offset, skip), и бэкенды по очереди
+//     занимают свободные ячейки в порядке своих перестановок.
+//   - Таблица строится по набору живых бэкендов и перестраивается только при его изменении.
+//     Если бэкенд ключа нужно пропустить (повтор запроса, открытый выключатель), выбирается
+//     бэкенд следующей ячейки, а сама таблица не меняется.
+//   - Готовая таблица неизменяема и публикуется атомарно, поэтому поиск не требует блокировок.
+//   - Если все бэкенды недоступны - возвращается nil.
+package balancer
+
+import (
+	"hash/fnv"
+	"net/http"
+	"sync"
+	"sync/atomic"
+
+	"github.com/mirskow/load-balancer/internal/backends"
+)
+
+// defaultMaglevTableSize - размер таблицы по умолчанию. Должен быть простым числом,
+// заметно большим количества бэкендов (в статье Maglev рекомендуется M > 100·N).
+const defaultMaglevTableSize = 65537
+
+type Maglev struct {
+	hashKey   HashKeyFunc
+	tableSize uint64
+
+	mu    sync.Mutex // сериализует перестроение таблицы
+	table atomic.Pointer[maglevTable]
+}
+
+// maglevTable - неизменяемая таблица поиска, построенная для конкретного набора бэкендов.
+type maglevTable struct {
+	members []*backends.Backend
+	entries []*backends.Backend
+}
+
+// NewMaglev создает новый экземпляр Maglev с заданной функцией ключа и размером таблицы
+// (0 - значение по умолчанию). Размер таблицы должен быть простым числом.
+func NewMaglev(hashKey HashKeyFunc, tableSize int) *Maglev {
+	if tableSize <= 0 {
+		tableSize = defaultMaglevTableSize
+	}
+
+	return &Maglev{
+		hashKey:   hashKey,
+		tableSize: uint64(tableSize),
+	}
+}
+
+// NextBackend выбирает бэкенд для пустого ключа. Используется, только если запрос недоступен.
+func (m *Maglev) NextBackend(pool []*backends.Backend) *backends.Backend {
+	return m.lookup("", pool)
+}
+
+// NextBackendForRequest выбирает бэкенд, которому в таблице принадлежит ключ запроса.
+func (m *Maglev) NextBackendForRequest(r *http.Request, pool []*backends.Backend) *backends.Backend {
+	return m.lookup(m.hashKey(r), pool)
+}
+
+// NextBackendExcluding выбирает для ключа запроса ближайшую по таблице ячейку бэкенда, для которого excluded
+// вернул false. Таблица строится по всему пулу, поэтому исключение бэкендов не вызывает ее перестроения.
+func (m *Maglev) NextBackendExcluding(r *http.Request, pool []*backends.Backend, excluded func(*backends.Backend) bool) *backends.Backend {
+	table := m.tableFor(aliveOnly(pool))
+	if !hasCandidate(table.members, excluded) {
+		return nil
+	}
+
+	start := maglevHash(m.hashKey(r), 0) % m.tableSize
+	for i := uint64(0); i < m.tableSize; i++ {
+		if b := table.entries[(start+i)%m.tableSize]; !excluded(b) {
+			return b
+		}
+	}
+
+	return nil
+}
+
+func (m *Maglev) lookup(key string, pool []*backends.Backend) *backends.Backend {
+	table := m.tableFor(aliveOnly(pool))
+	if len(table.entries) == 0 {
+		return nil
+	}
+
+	return table.entries[maglevHash(key, 0)%m.tableSize]
+}
+
+// tableFor возвращает таблицу для заданного набора бэкендов, перестраивая ее при необходимости.
+func (m *Maglev) tableFor(members []*backends.Backend) *maglevTable {
+	if table := m.table.Load(); table != nil && sameBackends(table.members, members) {
+		return table
+	}
+
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	if table := m.table.Load(); table != nil && sameBackends(table.members, members) {
+		return table
+	}
+
+	table := m.buildTable(members)
+	m.table.Store(table)
+
+	return table
+}
+
+func (m *Maglev) buildTable(members []*backends.Backend) *maglevTable {
+	table := &maglevTable{members: members}
+	if len(members) == 0 {
+		return table
+	}
+
+	size := m.tableSize
+	offsets := make([]uint64, len(members))
+	skips := make([]uint64, len(members))
+	for i, b := range members {
+		name := b.URL.String()
+		offsets[i] = maglevHash(name, 1) % size
+		skips[i] = maglevHash(name, 2)%(size-1) + 1
+	}
+
+	next := make([]uint64, len(members))
+	entries := make([]*backends.Backend, size)
+
+	for filled := uint64(0); ; {
+		for i, b := range members {
+			slot := (offsets[i] + next[i]*skips[i]) % size
+			for entries[slot] != nil {
+				next[i]++
+				slot = (offsets[i] + next[i]*skips[i]) % size
+			}
+
+			entries[slot] = b
+			next[i]++
+			filled++
+
+			if filled == size {
+				table.entries = entries
+				return table
+			}
+		}
+	}
+}
+
+// maglevHash вычисляет 64-битный хеш строки с заданным seed (FNV-1a).
+func maglevHash(s string, seed byte) uint64 {
+	h := fnv.New64a()
+	h.Write([]byte{seed})
+	h.Write([]byte(s))
+	return h.Sum64()
+}
@@ -0,0 +1,241 @@
+package balancer
+
+import (
+	"context"
+	"log"
+	"math"
+	"net/http"
+	"sync"
+	"time"
+
+	"github.com/mirskow/load-balancer/internal/backends"
+	"github.com/mirskow/load-balancer/internal/config"
+)
+
+// Значения по умолчанию совпадают со значениями outlier detection в Envoy.
+const (
+	defaultOutlierInterval          = 10 * time.Second
+	defaultConsecutive5xx           = 5
+	defaultConsecutiveGatewayErrors = 5
+	defaultBaseEjectionTime         = 30 * time.Second
+	defaultMaxEjectionTime          = 300 * time.Second
+	defaultMaxEjectionPercent       = 10
+	defaultSuccessRateMinimumHosts  = 5
+	defaultSuccessRateRequestVolume = 100
+	defaultSuccessRateStdevFactor   = 1.9
+)
+
+// outlierDetector реализует пассивное обнаружение выбросов (как в Envoy) по реальному трафику.
+//
+// Бэкенд извлекается из ротации, если:
+//   - подряд вернул заданное число ответов 5xx;
+//   - подряд вернул заданное число ошибок шлюза (502, 503, 504 или ошибка соединения);
+//   - его доля успешных ответов за интервал заметно ниже средней по пулу
+//     (меньше mean - stdevFactor·stdev).
+//
+// Время извлечения равно baseEjectionTime, умноженному на число извлечений подряд, но не больше
+// maxEjectionTime. Одновременно извлекается не больше maxEjectionPercent бэкендов пула.
+type outlierDetector struct {
+	interval                 time.Duration
+	consecutive5xx           int
+	consecutiveGatewayErrors int
+	baseEjectionTime         time.Duration
+	maxEjectionTime          time.Duration
+	maxEjectionPercent       int
+	successRateMinimumHosts  int
+	successRateRequestVolume int
+	successRateStdevFactor   float64
+
+	pool []*backends.Backend
+
+	mu    sync.Mutex
+	stats map[*backends.Backend]*outlierStats
+}
+
+type outlierStats struct {
+	consecutive5xx           int
+	consecutiveGatewayErrors int
+	requests                 int // запросов за текущий интервал
+	successes                int // успешных ответов за текущий интервал
+	ejections                int // множитель времени извлечения
+}
+
+func newOutlierDetector(cfg config.OutlierDetectionConfig, pool []*backends.Backend) *outlierDetector {
+	d := &outlierDetector{
+		interval:                 cfg.Interval,
+		consecutive5xx:           cfg.Consecutive5xx,
+		consecutiveGatewayErrors: cfg.ConsecutiveGatewayErrors,
+		baseEjectionTime:         cfg.BaseEjectionTime,
+		maxEjectionTime:          cfg.MaxEjectionTime,
+		maxEjectionPercent:       cfg.MaxEjectionPercent,
+		successRateMinimumHosts:  cfg.SuccessRateMinimumHosts,
+		successRateRequestVolume: cfg.SuccessRateRequestVolume,
+		successRateStdevFactor:   cfg.SuccessRateStdevFactor,
+		pool:                     pool,
+		stats:                    make(map[*backends.Backend]*outlierStats, len(pool)),
+	}
+
+	if d.interval <= 0 {
+		d.interval = defaultOutlierInterval
+	}
+	if d.consecutive5xx <= 0 {
+		d.consecutive5xx = defaultConsecutive5xx
+	}
+	if d.consecutiveGatewayErrors <= 0 {
+		d.consecutiveGatewayErrors = defaultConsecutiveGatewayErrors
+	}
+	if d.baseEjectionTime <= 0 {
+		d.baseEjectionTime = defaultBaseEjectionTime
+	}
+	if d.maxEjectionTime <= 0 {
+		d.maxEjectionTime = max(defaultMaxEjectionTime, d.baseEjectionTime)
+	}
+	if d.maxEjectionPercent <= 0 {
+		d.maxEjectionPercent = defaultMaxEjectionPercent
+	}
+	if d.successRateMinimumHosts <= 0 {
+		d.successRateMinimumHosts = defaultSuccessRateMinimumHosts
+	}
+	if d.successRateRequestVolume <= 0 {
+		d.successRateRequestVolume = defaultSuccessRateRequestVolume
+	}
+	if d.successRateStdevFactor <= 0 {
+		d.successRateStdevFactor = defaultSuccessRateStdevFactor
+	}
+
+	for _, b := range pool {
+		d.stats[b] = &outlierStats{}
+	}
+
+	return d
+}
+
+// observeStatus учитывает код ответа бэкенда.
+func (d *outlierDetector) observeStatus(b *backends.Backend, status int) {
+	d.mu.Lock()
+	defer d.mu.Unlock()
+
+	st, ok := d.stats[b]
+	if !ok {
+		return
+	}
+
+	st.requests++
+	if status < http.StatusInternalServerError {
+		st.successes++
+		st.consecutive5xx = 0
+		st.consecutiveGatewayErrors = 0
+		return
+	}
+
+	st.consecutive5xx++
+	if isGatewayError(status) {
+		st.consecutiveGatewayErrors++
+	} else {
+		st.consecutiveGatewayErrors = 0
+	}
+
+	switch {
+	case st.consecutive5xx >= d.consecutive5xx:
+		d.eject(b, st, "consecutive 5xx")
+	case st.consecutiveGatewayErrors >= d.consecutiveGatewayErrors:
+		d.eject(b, st, "consecutive gateway errors")
+	}
+}
+
+// observeError учитывает ошибку проксирования (бэкенд не ответил) как ошибку шлюза.
+func (d *outlierDetector) observeError(b *backends.Backend) {
+	d.observeStatus(b, http.StatusBadGateway)
+}
+
+func isGatewayError(status int) bool {
+	return status == http.StatusBadGateway ||
+		status == http.StatusServiceUnavailable ||
+		status == http.StatusGatewayTimeout
+}
+
+// eject извлекает бэкенд из ротации, если не превышен maxEjectionPercent. Вызывается под d.mu.
+func (d *outlierDetector) eject(b *backends.Backend, st *outlierStats, reason string) {
+	st.consecutive5xx = 0
+	st.consecutiveGatewayErrors = 0
+
+	if b.IsEjected() {
+		return
+	}
+
+	ejected := 0
+	for _, other := range d.pool {
+		if other.IsEjected() {
+			ejected++
+		}
+	}
+	if ejected*100 >= d.maxEjectionPercent*len(d.pool) {
+		log.Printf("[BALANCER - Outlier] %s is an outlier (%s), but max ejection percent reached\n", b.URL, reason)
+		return
+	}
+
+	st.ejections++
+	duration := min(d.baseEjectionTime*time.Duration(st.ejections), d.maxEjectionTime)
+	b.Eject(duration)
+
+	log.Printf("[BALANCER - Outlier] Ejected %s for %s: %s\n", b.URL, duration, reason)
+}
+
+// run периодически проверяет долю успешных ответов и сбрасывает статистику интервала.
+func (d *outlierDetector) run(ctx context.Context) {
+	t := time.NewTicker(d.interval)
+	defer t.Stop()
+
+	for {
+		select {
+		case <-ctx.Done():
+			log.Println("[BALANCER] Outlier detection loop stopped")
+			return
+		case <-t.C:
+			d.checkSuccessRate()
+		}
+	}
+}
+
+func (d *outlierDetector) checkSuccessRate() {
+	d.mu.Lock()
+	defer d.mu.Unlock()
+
+	rates := make(map[*backends.Backend]float64, len(d.stats))
+	var sum float64
+
+	for b, st := range d.stats {
+		if !b.IsEjected() && st.ejections > 0 {
+			// Бэкенд провел интервал в ротации - уменьшаем множитель следующего извлечения.
+			st.ejections--
+		}
+
+		if st.requests >= d.successRateRequestVolume {
+			rate := float64(st.successes) / float64(st.requests)
+			rates[b] = rate
+			sum += rate
+		}
+
+		st.requests = 0
+		st.successes = 0
+	}
+
+	if len(rates) < d.successRateMinimumHosts {
+		return
+	}
+
+	mean := sum / float64(len(rates))
+
+	var variance float64
+	for _, rate := range rates {
+		variance += (rate - mean) * (rate - mean)
+	}
+	stdev := math.Sqrt(variance / float64(len(rates)))
+
+	threshold := mean - d.successRateStdevFactor*stdev
+	for b, rate := range rates {
+		if rate < threshold {
+			d.eject(b, d.stats[b], "low success rate")
+		}
+	}
+}
@@ -0,0 +1,79 @@
+// Реализация стратегии балансировки нагрузки Power of Two Choices (P2C).
+//
+// Стратегия выбирает два случайных живых бэкенда и отправляет запрос на менее загруженный из них.
+// Это дает почти тот же эффект, что и Least Connections, но без просмотра всего пула,
+// что важно для пулов из сотен бэкендов.
+//
+// Особенности реализации:
+//   - Загрузка бэкенда оценивается функцией нагрузки: количеством запросов в обработке (по умолчанию)
+//     или скользящим средним времени ответа (LatencyLoad).
+//   - Бэкенд в режиме плавного ввода (slow start) считается пропорционально более загруженным.
+//   - Используется потокобезопасный генератор math/rand/v2, поэтому стратегия не хранит состояния
+//     и безопасна при конкурентных вызовах.
+//   - Если все бэкенды недоступны - возвращается nil.
+package balancer
+
+import (
+	"math/rand/v2"
+
+	"github.com/mirskow/load-balancer/internal/backends"
+)
+
+// LoadFunc возвращает оценку загрузки бэкенда: чем меньше значение, тем предпочтительнее бэкенд.
+type LoadFunc func(*backends.Backend) float64
+
+// ActiveRequestsLoad оценивает загрузку бэкенда по количеству запросов в обработке.
+func ActiveRequestsLoad(b *backends.Backend) float64 {
+	return float64(b.ActiveRequests())
+}
+
+// LatencyLoad оценивает загрузку бэкенда как latency × (inflight+1) по пиковому скользящему среднему.
+func LatencyLoad(b *backends.Backend) float64 {
+	return peakEWMACost(b)
+}
+
+type PowerOfTwoChoices struct {
+	load LoadFunc
+}
+
+// NewPowerOfTwoChoices создает новый экземпляр P2C с заданной функцией нагрузки.
+// Если функция не задана, используется ActiveRequestsLoad.
+func NewPowerOfTwoChoices(load LoadFunc) *PowerOfTwoChoices {
+	if load == nil {
+		load = ActiveRequestsLoad
+	}
+
+	return &PowerOfTwoChoices{
+		load: load,
+	}
+}
+
+// NextBackend выбирает менее загруженный из двух случайных живых бэкендов.
+func (p *PowerOfTwoChoices) NextBackend(pool []*backends.Backend) *backends.Backend {
+	alive := pool
+	for _, b := range pool {
+		if !b.IsAlive() {
+			alive = aliveOnly(pool)
+			break
+		}
+	}
+
+	switch len(alive) {
+	case 0:
+		return nil
+	case 1:
+		return alive[0]
+	}
+
+	i := rand.IntN(len(alive))
+	j := rand.IntN(len(alive) - 1)
+	if j >= i {
+		j++
+	}
+
+	first, second := alive[i], alive[j]
+	if slowStartLoad(second, p.load(second)) < slowStartLoad(first, p.load(first)) {
+		return second
+	}
+	return first
+}
@@ -0,0 +1,76 @@
+// Реализация стратегии балансировки нагрузки Peak EWMA (как в Finagle и Linkerd).
+//
+// Стратегия учитывает не только количество запросов в обработке, но и время ответа бэкенда:
+// запрос уходит на бэкенд с наименьшей стоимостью latency × (inflight+1), где latency -
+// пиковое скользящее среднее времени ответа. Бэкенд, который "жив", но отвечает вдвое медленнее
+// из-за шумного соседа, автоматически получает меньше трафика.
+//
+// Особенности реализации:
+//   - Скользящее среднее хранится в бэкенде и обновляется после каждого проксированного запроса.
+//   - Бэкенд без замеров и без запросов в обработке имеет нулевую стоимость и получает запрос
+//     первым, чтобы появился замер. Бэкенд без замеров, но с запросами в обработке штрафуется.
+//   - Бэкенд в режиме плавного ввода (slow start) считается пропорционально более дорогим.
+//   - При равной стоимости выбор начинается со смещения, которое сдвигается атомарно.
+//   - Если все бэкенды недоступны - возвращается nil.
+package balancer
+
+import (
+	"sync/atomic"
+	"time"
+
+	"github.com/mirskow/load-balancer/internal/backends"
+)
+
+// peakEWMAPenalty - стоимость запроса к бэкенду, для которого еще нет замеров времени ответа.
+const peakEWMAPenalty = float64(time.Second)
+
+type PeakEWMA struct {
+	offset uint64
+}
+
+// NewPeakEWMA создает новый экземпляр стратегии PeakEWMA.
+func NewPeakEWMA() *PeakEWMA {
+	return &PeakEWMA{}
+}
+
+// NextBackend выбирает живой бэкенд с наименьшей стоимостью latency × (inflight+1).
+func (p *PeakEWMA) NextBackend(pool []*backends.Backend) *backends.Backend {
+	countBackends := len(pool)
+	if countBackends == 0 {
+		return nil
+	}
+
+	start := int(atomic.AddUint64(&p.offset, uint64(1)) % uint64(countBackends))
+
+	var (
+		best     *backends.Backend
+		bestCost float64
+	)
+
+	for i := 0; i < countBackends; i++ {
+		b := pool[(start+i)%countBackends]
+		if !b.IsAlive() {
+			continue
+		}
+
+		cost := slowStartLoad(b, peakEWMACost(b))
+		if best == nil || cost < bestCost {
+			best = b
+			bestCost = cost
+		}
+	}
+
+	return best
+}
+
+// peakEWMACost вычисляет стоимость отправки запроса на бэкенд.
+func peakEWMACost(b *backends.Backend) float64 {
+	latency := float64(b.LatencyEWMA())
+	active := float64(b.ActiveRequests())
+
+	if latency == 0 && active > 0 {
+		return peakEWMAPenalty + active
+	}
+
+	return latency * (active + 1)
+}
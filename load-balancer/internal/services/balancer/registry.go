@@ -0,0 +1,132 @@
+package balancer
+
+import (
+	"fmt"
+	"sort"
+	"strings"
+	"sync"
+
+	"github.com/mirskow/load-balancer/internal/config"
+)
+
+// Имена встроенных стратегий балансировки для ключа balancer.strategy.
+const (
+	StrategyRoundRobin         = "roundrobin"
+	StrategyWeightedRoundRobin = "weighted-roundrobin"
+	StrategyLeastConnections   = "least-connections"
+	StrategyConsistentHash     = "consistent-hash"
+	StrategyPowerOfTwoChoices  = "p2c"
+	StrategyPeakEWMA           = "peak-ewma"
+	StrategyMaglev             = "maglev"
+)
+
+// Значения ключа balancer.p2c.load.
+const (
+	P2CLoadInflight = "inflight"
+	P2CLoadLatency  = "latency"
+)
+
+// StrategyFactory создает стратегию балансировки по конфигурации балансировщика.
+type StrategyFactory func(cfg config.BalancerConfig) (BalancingStrategy, error)
+
+var (
+	registryMu sync.RWMutex
+	registry   = map[string]StrategyFactory{
+		StrategyRoundRobin: func(config.BalancerConfig) (BalancingStrategy, error) {
+			return NewRoundRobin(1), nil
+		},
+		StrategyWeightedRoundRobin: func(config.BalancerConfig) (BalancingStrategy, error) {
+			return NewWeightedRoundRobin(), nil
+		},
+		StrategyLeastConnections: func(config.BalancerConfig) (BalancingStrategy, error) {
+			return NewLeastConnections(), nil
+		},
+		StrategyConsistentHash: func(cfg config.BalancerConfig) (BalancingStrategy, error) {
+			hashKey, err := NewHashKeyFunc(cfg.Hash.Key, cfg.Hash.Name)
+			if err != nil {
+				return nil, err
+			}
+			return NewConsistentHash(hashKey, cfg.Hash.VirtualNodes), nil
+		},
+		StrategyPowerOfTwoChoices: func(cfg config.BalancerConfig) (BalancingStrategy, error) {
+			switch cfg.P2C.Load {
+			case P2CLoadInflight, "":
+				return NewPowerOfTwoChoices(ActiveRequestsLoad), nil
+			case P2CLoadLatency:
+				return NewPowerOfTwoChoices(LatencyLoad), nil
+			default:
+				return nil, fmt.Errorf("unknown p2c load metric %q", cfg.P2C.Load)
+			}
+		},
+		StrategyPeakEWMA: func(config.BalancerConfig) (BalancingStrategy, error) {
+			return NewPeakEWMA(), nil
+		},
+		StrategyMaglev: func(cfg config.BalancerConfig) (BalancingStrategy, error) {
+			hashKey, err := NewHashKeyFunc(cfg.Hash.Key, cfg.Hash.Name)
+			if err != nil {
+				return nil, err
+			}
+			if cfg.Hash.TableSize != 0 && !isPrime(cfg.Hash.TableSize) {
+				return nil, fmt.Errorf("maglev table size %d is not a prime number", cfg.Hash.TableSize)
+			}
+			return NewMaglev(hashKey, cfg.Hash.TableSize), nil
+		},
+	}
+)
+
+// RegisterStrategy регистрирует фабрику стратегии под заданным именем.
+// Повторная регистрация заменяет ранее зарегистрированную фабрику.
+func RegisterStrategy(name string, factory StrategyFactory) {
+	registryMu.Lock()
+	defer registryMu.Unlock()
+
+	registry[name] = factory
+}
+
+// NewStrategy создает стратегию, указанную в cfg.Strategy. Пустое имя означает Round Robin.
+func NewStrategy(cfg config.BalancerConfig) (BalancingStrategy, error) {
+	name := cfg.Strategy
+	if name == "" {
+		name = StrategyRoundRobin
+	}
+
+	registryMu.RLock()
+	factory, ok := registry[name]
+	registryMu.RUnlock()
+
+	if !ok {
+		return nil, fmt.Errorf("unknown balancing strategy %q (available: %s)", name, strings.Join(strategyNames(), ", "))
+	}
+
+	strategy, err := factory(cfg)
+	if err != nil {
+		return nil, fmt.Errorf("strategy %q: %w", name, err)
+	}
+
+	return strategy, nil
+}
+
+func strategyNames() []string {
+	registryMu.RLock()
+	defer registryMu.RUnlock()
+
+	names := make([]string, 0, len(registry))
+	for name := range registry {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+
+	return names
+}
+
+func isPrime(n int) bool {
+	if n < 2 {
+		return false
+	}
+	for d := 2; d*d <= n; d++ {
+		if n%d == 0 {
+			return false
+		}
+	}
+	return true
+}
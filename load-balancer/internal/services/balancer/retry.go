@@ -0,0 +1,171 @@
+package balancer
+
+import (
+	"bytes"
+	"errors"
+	"io"
+	"log"
+	"net/http"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/mirskow/load-balancer/internal/backends"
+	"github.com/mirskow/load-balancer/internal/config"
+	"github.com/mirskow/load-balancer/internal/requestid"
+)
+
+const (
+	defaultRetryMaxAttempts  = 3
+	defaultRetryMaxBodyBytes = 64 << 10
+
+	// retriesHeader - заголовок ответа с количеством выполненных повторов.
+	retriesHeader = "X-LB-Retries"
+)
+
+// errHeaderTimeout - ошибка попытки, на которую бэкенд не прислал заголовки ответа за perTryTimeout.
+var errHeaderTimeout = errors.New("per-try timeout waiting for response headers")
+
+// defaultRetryMethods - идемпотентные методы по RFC 9110, которые можно безопасно повторить.
+var defaultRetryMethods = []string{
+	http.MethodGet, http.MethodHead, http.MethodOptions, http.MethodTrace, http.MethodPut, http.MethodDelete,
+}
+
+// retryPolicy описывает, какие запросы и сколько раз можно повторить на другом бэкенде.
+type retryPolicy struct {
+	maxAttempts   int
+	perTryTimeout time.Duration
+	maxBodyBytes  int64
+	methods       map[string]bool
+	budget        *retryBudget
+}
+
+func newRetryPolicy(cfg config.RetryConfig) (*retryPolicy, error) {
+	budget, err := newRetryBudget(cfg.Budget)
+	if err != nil {
+		return nil, err
+	}
+
+	p := &retryPolicy{
+		maxAttempts:   cfg.MaxAttempts,
+		perTryTimeout: cfg.PerTryTimeout,
+		maxBodyBytes:  cfg.MaxBodyBytes,
+		methods:       make(map[string]bool),
+		budget:        budget,
+	}
+
+	if p.maxAttempts <= 0 {
+		p.maxAttempts = defaultRetryMaxAttempts
+	}
+	if p.maxBodyBytes <= 0 {
+		p.maxBodyBytes = defaultRetryMaxBodyBytes
+	}
+
+	methods := cfg.Methods
+	if len(methods) == 0 {
+		methods = defaultRetryMethods
+	}
+	for _, m := range methods {
+		p.methods[strings.ToUpper(m)] = true
+	}
+
+	return p, nil
+}
+
+// bufferBody читает тело запроса в память, если метод идемпотентный и тело не больше maxBodyBytes.
+// Возвращает тело и признак того, что запрос можно повторить. Если тело не помещается в буфер,
+// запрос остается пригодным для одной попытки: уже прочитанная часть возвращается в r.Body.
+func (p *retryPolicy) bufferBody(r *http.Request) ([]byte, bool) {
+	if !p.methods[r.Method] {
+		return nil, false
+	}
+
+	if r.Body == nil || r.Body == http.NoBody {
+		return nil, true
+	}
+
+	if r.ContentLength > p.maxBodyBytes {
+		return nil, false
+	}
+
+	body, err := io.ReadAll(io.LimitReader(r.Body, p.maxBodyBytes+1))
+	if err != nil {
+		log.Printf("[BALANCER] Error reading request body for retries: %v%s\n", err, requestid.Tag(r.Context()))
+		r.Body = io.NopCloser(bytes.NewReader(body))
+		return nil, false
+	}
+
+	if int64(len(body)) > p.maxBodyBytes {
+		r.Body = struct {
+			io.Reader
+			io.Closer
+		}{io.MultiReader(bytes.NewReader(body), r.Body), r.Body}
+		return nil, false
+	}
+
+	r.Body.Close()
+	r.ContentLength = int64(len(body))
+
+	return body, true
+}
+
+// forwardWithRetries проксирует запрос и при ошибке проксирования повторяет его на другом бэкенде,
+// пока не исчерпан лимит попыток или бюджет повторов. Количество повторов возвращается клиенту в заголовке X-LB-Retries.
+func (lb *LoadBalancer) forwardWithRetries(w http.ResponseWriter, r *http.Request, backend *backends.Backend) {
+	body, retryable := lb.retry.bufferBody(r)
+	tried := make([]*backends.Backend, 0, lb.retry.maxAttempts)
+
+	// Таймаут попытки нужен только запросу, который можно повторить: иначе он лишь обрывает медленный ответ.
+	var headerTimeout time.Duration
+	if retryable {
+		headerTimeout = lb.retry.perTryTimeout
+	}
+
+	for retries := 0; ; retries++ {
+		if body != nil {
+			r.Body = io.NopCloser(bytes.NewReader(body))
+		}
+
+		w.Header().Set(retriesHeader, strconv.Itoa(retries))
+
+		canRetry := retryable && retries+1 < lb.retry.maxAttempts
+		at := lb.forward(w, r, backend, canRetry, headerTimeout)
+		if at.err == nil {
+			// Запрос, прерванный клиентом, не считается успешным и не пополняет бюджет.
+			if r.Context().Err() == nil {
+				lb.retry.budget.deposit()
+			}
+			return
+		}
+		if !canRetry {
+			return
+		}
+
+		tried = append(tried, backend)
+
+		// Бюджет расходуется, только если есть бэкенд для повтора.
+		next, _ := lb.pickBackendExcluding(r, lb.getAliveBackends(), tried)
+		if next == nil {
+			http.Error(w, "Backend unavailable", http.StatusServiceUnavailable)
+			return
+		}
+		if !lb.retry.budget.withdraw() {
+			// Пробный слот выключателя, занятый при выборе бэкенда, не понадобился.
+			if breaker := lb.breakers[next]; breaker != nil {
+				breaker.release()
+			}
+			http.Error(w, "Backend unavailable", http.StatusServiceUnavailable)
+			return
+		}
+
+		used, limit := lb.retry.budget.usage()
+		log.Printf("[BALANCER] Retrying request on %s after error from %s (retry budget %d/%d)%s\n", next.URL, backend.URL, used, limit, requestid.Tag(r.Context()))
+
+		if lb.sticky != nil {
+			lb.sticky.unpin(w)
+			lb.sticky.pin(w, next)
+		}
+
+		backend = next
+	}
+}
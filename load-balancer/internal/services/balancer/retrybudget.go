@@ -0,0 +1,135 @@
+package balancer
+
+import (
+	"fmt"
+	"log"
+	"sync"
+	"time"
+
+	"github.com/mirskow/load-balancer/internal/config"
+)
+
+const (
+	defaultRetryBudgetPercent   = 20
+	defaultRetryBudgetMinPerSec = 10
+	defaultRetryBudgetTTL       = 10 * time.Second
+	retryBudgetBuckets          = 10
+	retryBudgetLogInterval      = time.Second
+
+	// minRetryBudgetTTL - минимальное окно бюджета: окно делится на retryBudgetBuckets корзин,
+	// и слишком короткое окно теряет смысл.
+	minRetryBudgetTTL = time.Second
+)
+
+// retryBudgetBucket - статистика запросов и повторов за часть окна бюджета.
+type retryBudgetBucket struct {
+	start     time.Time
+	successes int
+	retries   int
+}
+
+// retryBudget ограничивает долю повторов (как RetryBudget в Finagle): за последние ttl повторов
+// может быть не больше percent процентов от успешных запросов плюс minPerSec повторов в секунду.
+// Когда бюджет исчерпан, ошибки сразу возвращаются клиенту, и повторы не умножают нагрузку
+// на и без того перегруженные бэкенды.
+type retryBudget struct {
+	percent   int
+	minPerSec int
+	ttl       time.Duration
+
+	mu       sync.Mutex
+	buckets  [retryBudgetBuckets]retryBudgetBucket
+	rejected int       // отклоненных повторов с момента последней записи в лог
+	loggedAt time.Time // время последней записи в лог об исчерпании бюджета
+}
+
+func newRetryBudget(cfg config.RetryBudgetConfig) (*retryBudget, error) {
+	b := &retryBudget{
+		percent:   cfg.Percent,
+		minPerSec: cfg.MinRetriesPerSec,
+		ttl:       cfg.TTL,
+	}
+
+	if b.percent <= 0 {
+		b.percent = defaultRetryBudgetPercent
+	}
+	if b.minPerSec < 0 {
+		b.minPerSec = 0
+	} else if b.minPerSec == 0 {
+		b.minPerSec = defaultRetryBudgetMinPerSec
+	}
+	if b.ttl == 0 {
+		b.ttl = defaultRetryBudgetTTL
+	}
+	if b.ttl < minRetryBudgetTTL {
+		return nil, fmt.Errorf("retry budget ttl must be at least %s, got %s", minRetryBudgetTTL, b.ttl)
+	}
+
+	return b, nil
+}
+
+// deposit учитывает успешный запрос.
+func (b *retryBudget) deposit() {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+
+	b.bucket(time.Now()).successes++
+}
+
+// withdraw пытается израсходовать бюджет на один повтор. Возвращает false, если бюджет исчерпан.
+func (b *retryBudget) withdraw() bool {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+
+	now := time.Now()
+	successes, retries := b.totals(now)
+	limit := b.limit(successes)
+
+	if retries >= limit {
+		b.rejected++
+		if now.Sub(b.loggedAt) >= retryBudgetLogInterval {
+			log.Printf("[BALANCER - RetryBudget] Budget exhausted: %d/%d retries used, %d retries rejected\n", retries, limit, b.rejected)
+			b.rejected = 0
+			b.loggedAt = now
+		}
+		return false
+	}
+
+	b.bucket(now).retries++
+	return true
+}
+
+// usage возвращает количество повторов и лимит повторов за текущее окно.
+func (b *retryBudget) usage() (retries, limit int) {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+
+	successes, retries := b.totals(time.Now())
+	return retries, b.limit(successes)
+}
+
+func (b *retryBudget) limit(successes int) int {
+	return successes*b.percent/100 + int(float64(b.minPerSec)*b.ttl.Seconds())
+}
+
+func (b *retryBudget) totals(now time.Time) (successes, retries int) {
+	for _, bucket := range b.buckets {
+		if now.Sub(bucket.start) < b.ttl {
+			successes += bucket.successes
+			retries += bucket.retries
+		}
+	}
+	return successes, retries
+}
+
+// bucket возвращает корзину окна для текущего момента, сбрасывая устаревшую.
+func (b *retryBudget) bucket(now time.Time) *retryBudgetBucket {
+	width := b.ttl / retryBudgetBuckets
+	start := now.Truncate(width)
+	bucket := &b.buckets[(start.UnixNano()/int64(width))%retryBudgetBuckets]
+
+	if !bucket.start.Equal(start) {
+		*bucket = retryBudgetBucket{start: start}
+	}
+	return bucket
+}
@@ -0,0 +1,264 @@
+package balancer
+
+import (
+	"context"
+	"fmt"
+	"net"
+	"net/http"
+	"net/url"
+	"regexp"
+	"strings"
+
+	"github.com/mirskow/load-balancer/internal/config"
+)
+
+const rewriteKey ctxKey = "rewrite"
+
+// rewriteRules - разобранные правила преобразования запроса и ответа маршрута.
+type rewriteRules struct {
+	stripPrefix     string
+	addPrefix       string
+	pathRegex       *regexp.Regexp
+	pathReplacement string
+	requestHeaders  config.HeaderRewriteConfig
+	responseHeaders config.HeaderRewriteConfig
+	domains         []config.DomainRewriteConfig
+}
+
+// rewriteState передает правила маршрута и исходный адрес запроса клиента в Director и ModifyResponse.
+type rewriteState struct {
+	rules    *rewriteRules
+	host     string // Host из запроса клиента
+	scheme   string // схема, по которой пришел клиент
+	stripped bool   // префикс stripPrefix снят с пути запроса
+}
+
+// newRewriteRules разбирает правила маршрута. Возвращает nil, если преобразования не заданы.
+func newRewriteRules(cfg config.RewriteConfig) (*rewriteRules, error) {
+	rules := &rewriteRules{
+		stripPrefix:     cfg.StripPrefix,
+		addPrefix:       cfg.AddPrefix,
+		pathReplacement: cfg.PathReplacement,
+		requestHeaders:  cfg.RequestHeaders,
+		responseHeaders: cfg.ResponseHeaders,
+		domains:         cfg.Domains,
+	}
+
+	if cfg.PathRegex != "" {
+		re, err := regexp.Compile(cfg.PathRegex)
+		if err != nil {
+			return nil, fmt.Errorf("rewrite path regex: %w", err)
+		}
+		rules.pathRegex = re
+	}
+
+	for _, d := range cfg.Domains {
+		if d.From == "" {
+			return nil, fmt.Errorf("rewrite domain without from")
+		}
+	}
+
+	if rules.isEmpty() {
+		return nil, nil
+	}
+
+	return rules, nil
+}
+
+// isEmpty сообщает, что правило не задает ни одного преобразования.
+func (rules *rewriteRules) isEmpty() bool {
+	return rules.stripPrefix == "" && rules.addPrefix == "" && rules.pathRegex == nil &&
+		isEmptyHeaderRewrite(rules.requestHeaders) && isEmptyHeaderRewrite(rules.responseHeaders) &&
+		len(rules.domains) == 0
+}
+
+func isEmptyHeaderRewrite(cfg config.HeaderRewriteConfig) bool {
+	return len(cfg.Set) == 0 && len(cfg.Add) == 0 && len(cfg.Remove) == 0
+}
+
+// withRewrite сохраняет правила маршрута в контексте запроса.
+func withRewrite(r *http.Request, rules *rewriteRules) *http.Request {
+	scheme := "http"
+	if r.TLS != nil {
+		scheme = "https"
+	}
+
+	_, stripped := rules.stripPath(r.URL.Path)
+	state := &rewriteState{rules: rules, host: r.Host, scheme: scheme, stripped: stripped}
+	return r.WithContext(context.WithValue(r.Context(), rewriteKey, state))
+}
+
+func rewriteFromContext(ctx context.Context) *rewriteState {
+	state, _ := ctx.Value(rewriteKey).(*rewriteState)
+	return state
+}
+
+// rewritePath преобразует путь запроса до того, как Director соединит его с путем бэкенда.
+func (rules *rewriteRules) rewritePath(req *http.Request) {
+	path, stripped := rules.stripPath(req.URL.Path)
+	if stripped {
+		req.Header.Set("X-Forwarded-Prefix", rules.stripPrefix)
+	}
+
+	if rules.pathRegex != nil {
+		path = rules.pathRegex.ReplaceAllString(path, rules.pathReplacement)
+	}
+
+	if rules.addPrefix != "" {
+		path = joinPath(rules.addPrefix, path)
+	}
+
+	if !strings.HasPrefix(path, "/") {
+		path = "/" + path
+	}
+
+	if path != req.URL.Path {
+		req.URL.Path = path
+		req.URL.RawPath = ""
+	}
+}
+
+// stripPath снимает stripPrefix с пути. Префикс снимается только целыми сегментами:
+// /service-x не снимается с /service-xyz. Второе значение сообщает, был ли префикс снят.
+func (rules *rewriteRules) stripPath(path string) (string, bool) {
+	if rules.stripPrefix == "" {
+		return path, false
+	}
+
+	rest, ok := strings.CutPrefix(path, rules.stripPrefix)
+	if ok && (rest == "" || strings.HasPrefix(rest, "/") || strings.HasSuffix(rules.stripPrefix, "/")) {
+		return rest, true
+	}
+	return path, false
+}
+
+// rewriteRequestHeaders применяет правила к заголовкам запроса. Заголовок Host меняет хост запроса к бэкенду.
+func (rules *rewriteRules) rewriteRequestHeaders(req *http.Request) {
+	for _, h := range rules.requestHeaders.Set {
+		if strings.EqualFold(h.Name, "Host") {
+			req.Host = h.Value
+			continue
+		}
+		req.Header.Set(h.Name, h.Value)
+	}
+
+	for _, h := range rules.requestHeaders.Add {
+		req.Header.Add(h.Name, h.Value)
+	}
+
+	for _, name := range rules.requestHeaders.Remove {
+		req.Header.Del(name)
+	}
+}
+
+// rewriteResponse применяет правила к заголовкам ответа бэкенда, включая Location и Set-Cookie.
+func (state *rewriteState) rewriteResponse(resp *http.Response) {
+	rules := state.rules
+
+	if location := resp.Header.Get("Location"); location != "" {
+		resp.Header.Set("Location", state.rewriteLocation(location))
+	}
+
+	if len(rules.domains) > 0 {
+		cookies := resp.Header.Values("Set-Cookie")
+		for i, cookie := range cookies {
+			cookies[i] = state.rewriteCookieDomain(cookie)
+		}
+	}
+
+	for _, h := range rules.responseHeaders.Set {
+		resp.Header.Set(h.Name, h.Value)
+	}
+
+	for _, h := range rules.responseHeaders.Add {
+		resp.Header.Add(h.Name, h.Value)
+	}
+
+	for _, name := range rules.responseHeaders.Remove {
+		resp.Header.Del(name)
+	}
+}
+
+// rewriteLocation переписывает домен бэкенда в абсолютном Location и, если префикс был снят
+// с пути запроса, возвращает его, чтобы редирект вел на публичный путь маршрута.
+// Location с чужим доменом не меняется.
+func (state *rewriteState) rewriteLocation(location string) string {
+	u, err := url.Parse(location)
+	if err != nil {
+		return location
+	}
+
+	if u.Host != "" {
+		to, ok := state.publicDomain(u.Hostname())
+		if !ok {
+			return location
+		}
+
+		if port := u.Port(); port != "" && !strings.Contains(to, ":") && to != state.host {
+			to = net.JoinHostPort(to, port)
+		}
+		u.Host = to
+		if to == state.host {
+			u.Scheme = state.scheme
+		}
+	}
+
+	if strings.HasPrefix(u.Path, "/") {
+		path := u.Path
+		if rules := state.rules; rules.addPrefix != "" || rules.stripPrefix != "" {
+			if rest, ok := strings.CutPrefix(path, rules.addPrefix); ok && rules.addPrefix != "" {
+				path = rest
+			}
+			if state.stripped {
+				path = joinPath(rules.stripPrefix, path)
+			}
+		}
+
+		if path != u.Path {
+			u.Path = path
+			u.RawPath = ""
+		}
+	}
+
+	return u.String()
+}
+
+// rewriteCookieDomain заменяет атрибут Domain в заголовке Set-Cookie.
+func (state *rewriteState) rewriteCookieDomain(cookie string) string {
+	parts := strings.Split(cookie, ";")
+	for i, part := range parts {
+		name, value, ok := strings.Cut(strings.TrimSpace(part), "=")
+		if !ok || !strings.EqualFold(name, "Domain") {
+			continue
+		}
+
+		to, ok := state.publicDomain(strings.TrimPrefix(value, "."))
+		if !ok {
+			continue
+		}
+
+		if host, _, err := net.SplitHostPort(to); err == nil {
+			to = host
+		}
+		parts[i] = " " + name + "=" + to
+	}
+	return strings.Join(parts, ";")
+}
+
+// publicDomain возвращает публичный домен для домена бэкенда, если для него задано правило.
+func (state *rewriteState) publicDomain(domain string) (string, bool) {
+	for _, d := range state.rules.domains {
+		if strings.EqualFold(domain, d.From) {
+			if d.To == "" {
+				return state.host, true
+			}
+			return d.To, true
+		}
+	}
+	return "", false
+}
+
+// joinPath соединяет префикс и путь ровно одним слешем.
+func joinPath(prefix, path string) string {
+	return strings.TrimSuffix(prefix, "/") + "/" + strings.TrimPrefix(path, "/")
+}
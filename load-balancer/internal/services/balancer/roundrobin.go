@@ -6,6 +6,7 @@
 // Особенности реализации:
 //   - Атомарное обновление индекса для поддержки конкурентного доступа.
 //   - Пропуск неработающих (неживых) бэкендов при выборе следующего.
+//   - Бэкенд в режиме плавного ввода (slow start) выбирается с вероятностью, равной его доле веса.
 //   - Если все бэкенды недоступны - возвращается nil.
 package balancer
 
@@ -36,15 +37,26 @@ func (rr *RoundRobin) NextBackend(backends []*backends.Backend) *backends.Backen
 	countBackends := len(backends)
 	nextIndex := rr.getNextIndex(countBackends)
 	end := countBackends + nextIndex
+	fallback := -1
 
 	for i := nextIndex; i < end; i++ {
 		index := i % countBackends
 		if backends[index].IsAlive() {
+			if !admitSlowStart(backends[index]) {
+				if fallback < 0 {
+					fallback = index
+				}
+				continue
+			}
 			if i != nextIndex {
 				atomic.StoreUint64(&rr.currentIndex, uint64(index))
 			}
 			return backends[index]
 		}
 	}
+
+	if fallback >= 0 {
+		return backends[fallback]
+	}
 	return nil
 }
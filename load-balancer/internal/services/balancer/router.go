@@ -0,0 +1,300 @@
+package balancer
+
+import (
+	"context"
+	"fmt"
+	"log"
+	"net"
+	"net/http"
+	"regexp"
+	"strings"
+
+	"github.com/mirskow/load-balancer/internal/config"
+	"github.com/mirskow/load-balancer/internal/events"
+	"github.com/mirskow/load-balancer/internal/requestid"
+)
+
+// DefaultPool - имя пула, составленного из бэкендов секции balancer. В него попадают запросы,
+// не подошедшие ни под один маршрут.
+const DefaultPool = "default"
+
+// Router выбирает для запроса пул бэкендов по маршрутам из конфигурации и передает запрос
+// балансировщику этого пула. У каждого пула свои бэкенды, стратегия и проверки состояния.
+type Router struct {
+	routes   []*route
+	pools    map[string]*LoadBalancer
+	fallback *LoadBalancer // nil, если в секции balancer нет бэкендов
+}
+
+// NewRouter создает балансировщики для всех пулов и разбирает маршруты.
+// Возвращает ошибку, если маршрут ссылается на неизвестный пул, имена пулов повторяются
+// или условие маршрута задано неверно.
+func NewRouter(ctx context.Context, cfg config.BalancerConfig, bus *events.Bus) (*Router, error) {
+	rt := &Router{pools: make(map[string]*LoadBalancer, len(cfg.Pools)+1)}
+
+	if len(cfg.Backends) > 0 {
+		lb, err := NewLoadBalancer(ctx, cfg, bus)
+		if err != nil {
+			return nil, fmt.Errorf("pool %s: %w", DefaultPool, err)
+		}
+		rt.pools[DefaultPool] = lb
+		rt.fallback = lb
+	}
+
+	for _, poolCfg := range cfg.Pools {
+		if poolCfg.Name == "" {
+			return nil, fmt.Errorf("pool without a name")
+		}
+		if _, exists := rt.pools[poolCfg.Name]; exists {
+			return nil, fmt.Errorf("duplicate pool name %q", poolCfg.Name)
+		}
+
+		// Пул без своего имени cookie привязки получает имя с суффиксом пула, чтобы клиент,
+		// попадающий в разные пулы, не перезаписывал привязку к бэкенду другого пула.
+		// Имя, заданное для пула явно, используется как есть.
+		poolBalancerCfg := poolCfg.BalancerConfig
+		if poolBalancerCfg.StickySession.CookieName == "" {
+			poolBalancerCfg.StickySession.CookieName = poolStickyCookieName(cfg.StickySession.CookieName, poolCfg.Name)
+		}
+
+		lb, err := NewLoadBalancer(ctx, poolBalancerCfg, bus)
+		if err != nil {
+			return nil, fmt.Errorf("pool %s: %w", poolCfg.Name, err)
+		}
+		rt.pools[poolCfg.Name] = lb
+	}
+
+	for i, routeCfg := range cfg.Routes {
+		route, err := newRoute(routeCfg)
+		if err != nil {
+			return nil, fmt.Errorf("route %d (%s): %w", i, routeCfg.Name, err)
+		}
+
+		if len(routeCfg.Split.Pools) > 0 {
+			if routeCfg.Pool != "" {
+				return nil, fmt.Errorf("route %d (%s): pool and split are mutually exclusive", i, routeCfg.Name)
+			}
+
+			route.split, err = newTrafficSplit(routeCfg.Split, rt.pools, cfg.StickySession.SigningKey)
+			if err != nil {
+				return nil, fmt.Errorf("route %d (%s): %w", i, routeCfg.Name, err)
+			}
+		} else {
+			pool, ok := rt.pools[routeCfg.Pool]
+			if !ok {
+				return nil, fmt.Errorf("route %d (%s): unknown pool %q", i, routeCfg.Name, routeCfg.Pool)
+			}
+			route.pool = pool
+		}
+
+		rt.routes = append(rt.routes, route)
+	}
+
+	return rt, nil
+}
+
+// Route передает запрос балансировщику пула первого подходящего маршрута.
+// Если ни один маршрут не подошел и пула по умолчанию нет, возвращает 503, как балансировщик без бэкендов.
+func (rt *Router) Route(w http.ResponseWriter, r *http.Request) {
+	for _, route := range rt.routes {
+		if route.matches(r) {
+			if route.rewrite != nil {
+				r = withRewrite(r, route.rewrite)
+			}
+
+			pool := route.pool
+			if route.split != nil {
+				pool = route.split.choose(w, r)
+			}
+			pool.Route(w, r)
+			return
+		}
+	}
+
+	if rt.fallback != nil {
+		rt.fallback.Route(w, r)
+		return
+	}
+
+	log.Printf("[BALANCER] No route for %s %s%s%s\n", r.Method, r.Host, r.URL.Path, requestid.Tag(r.Context()))
+	http.Error(w, "Service unavailable: no alive backend", http.StatusServiceUnavailable)
+}
+
+// UpdateSplitWeights применяет новые веса разделения трафика из конфигурации без перезапуска.
+// Остальные изменения маршрутов и пулов требуют перезапуска и игнорируются.
+func (rt *Router) UpdateSplitWeights(routes []config.RouteConfig) error {
+	if len(routes) != len(rt.routes) {
+		return fmt.Errorf("routes cannot be added or removed at runtime")
+	}
+
+	for i, route := range rt.routes {
+		if route.split == nil {
+			continue
+		}
+
+		if err := route.split.setWeights(routes[i].Split); err != nil {
+			return fmt.Errorf("route %d (%s): %w", i, routes[i].Name, err)
+		}
+	}
+
+	return nil
+}
+
+// route - разобранный маршрут из конфигурации.
+type route struct {
+	host       string
+	wildcard   bool // host задан шаблоном *.example.com, в host хранится суффикс .example.com
+	pathPrefix string
+	pathRegex  *regexp.Regexp
+	methods    map[string]bool
+	headers    []matcher
+	query      []matcher
+	cookies    []matcher
+	rewrite    *rewriteRules // nil, если преобразования не заданы
+	pool       *LoadBalancer
+	split      *trafficSplit // задается вместо pool, если трафик делится между пулами
+}
+
+func newRoute(cfg config.RouteConfig) (*route, error) {
+	rt := &route{
+		host:       strings.ToLower(cfg.Host),
+		pathPrefix: cfg.PathPrefix,
+	}
+
+	if suffix, ok := strings.CutPrefix(rt.host, "*"); ok {
+		rt.host = suffix
+		rt.wildcard = true
+	}
+
+	if cfg.PathRegex != "" {
+		re, err := regexp.Compile(cfg.PathRegex)
+		if err != nil {
+			return nil, fmt.Errorf("path regex: %w", err)
+		}
+		rt.pathRegex = re
+	}
+
+	if len(cfg.Methods) > 0 {
+		rt.methods = make(map[string]bool, len(cfg.Methods))
+		for _, m := range cfg.Methods {
+			rt.methods[strings.ToUpper(m)] = true
+		}
+	}
+
+	var err error
+	if rt.headers, err = newMatchers(cfg.Headers); err != nil {
+		return nil, fmt.Errorf("headers: %w", err)
+	}
+	if rt.query, err = newMatchers(cfg.Query); err != nil {
+		return nil, fmt.Errorf("query: %w", err)
+	}
+	if rt.cookies, err = newMatchers(cfg.Cookies); err != nil {
+		return nil, fmt.Errorf("cookies: %w", err)
+	}
+	if rt.rewrite, err = newRewriteRules(cfg.Rewrite); err != nil {
+		return nil, err
+	}
+
+	return rt, nil
+}
+
+// matches сообщает, удовлетворяет ли запрос всем условиям маршрута.
+func (rt *route) matches(r *http.Request) bool {
+	if rt.host != "" && !rt.matchHost(r.Host) {
+		return false
+	}
+	if rt.pathPrefix != "" && !strings.HasPrefix(r.URL.Path, rt.pathPrefix) {
+		return false
+	}
+	if rt.pathRegex != nil && !rt.pathRegex.MatchString(r.URL.Path) {
+		return false
+	}
+	if rt.methods != nil && !rt.methods[r.Method] {
+		return false
+	}
+
+	for _, m := range rt.headers {
+		if !m.matchAny(r.Header.Values(m.name)) {
+			return false
+		}
+	}
+
+	if len(rt.query) > 0 {
+		query := r.URL.Query()
+		for _, m := range rt.query {
+			if !m.matchAny(query[m.name]) {
+				return false
+			}
+		}
+	}
+
+	for _, m := range rt.cookies {
+		cookie, err := r.Cookie(m.name)
+		if err != nil || !m.match(cookie.Value) {
+			return false
+		}
+	}
+
+	return true
+}
+
+func (rt *route) matchHost(hostport string) bool {
+	host := hostport
+	if h, _, err := net.SplitHostPort(hostport); err == nil {
+		host = h
+	}
+	host = strings.ToLower(host)
+
+	if rt.wildcard {
+		return strings.HasSuffix(host, rt.host) && len(host) > len(rt.host)
+	}
+	return host == rt.host
+}
+
+// matcher проверяет значение заголовка, параметра запроса или cookie.
+type matcher struct {
+	name  string
+	value string
+	regex *regexp.Regexp
+}
+
+func newMatchers(cfgs []config.MatchConfig) ([]matcher, error) {
+	matchers := make([]matcher, 0, len(cfgs))
+	for _, cfg := range cfgs {
+		if cfg.Name == "" {
+			return nil, fmt.Errorf("match without a name")
+		}
+
+		m := matcher{name: cfg.Name, value: cfg.Value}
+		if cfg.Regex != "" {
+			re, err := regexp.Compile(cfg.Regex)
+			if err != nil {
+				return nil, fmt.Errorf("%s: %w", cfg.Name, err)
+			}
+			m.regex = re
+		}
+		matchers = append(matchers, m)
+	}
+	return matchers, nil
+}
+
+func (m matcher) match(value string) bool {
+	switch {
+	case m.regex != nil:
+		return m.regex.MatchString(value)
+	case m.value != "":
+		return value == m.value
+	default:
+		return true
+	}
+}
+
+// matchAny сообщает, подходит ли под условие хотя бы одно из значений.
+func (m matcher) matchAny(values []string) bool {
+	for _, v := range values {
+		if m.match(v) {
+			return true
+		}
+	}
+	return false
+}
@@ -0,0 +1,30 @@
+// Реализация плавного ввода восстановленного бэкенда в ротацию (slow start) для стратегий балансировки.
+//
+// После восстановления доля веса бэкенда линейно растет от minWeightPercent до 100% за окно slowStart.window,
+// поэтому бэкенд с холодными кешами и пулами соединений не получает сразу полную долю трафика.
+//
+// Особенности реализации:
+//   - Доля веса вычисляется в backends.Backend (SlowStartFactor), стратегии только применяют ее.
+//   - Weighted Round Robin использует эффективный вес бэкенда - вес, умноженный на долю.
+//   - Round Robin выбирает бэкенд в режиме плавного ввода с вероятностью, равной его доле веса.
+//   - Стратегии по загрузке (Least Connections, P2C, Peak EWMA) считают такой бэкенд более загруженным.
+package balancer
+
+import (
+	"math/rand/v2"
+
+	"github.com/mirskow/load-balancer/internal/backends"
+)
+
+// admitSlowStart решает, может ли стратегия без весов (Round Robin) выбрать бэкенд.
+// Бэкенд в режиме плавного ввода принимается с вероятностью, равной его доле веса.
+func admitSlowStart(b *backends.Backend) bool {
+	factor := b.SlowStartFactor()
+	return factor >= 1 || rand.Float64() < factor
+}
+
+// slowStartLoad пересчитывает оценку загрузки с учетом плавного ввода в ротацию:
+// бэкенд с долей веса f выглядит для стратегий по загрузке в 1/f раз более загруженным.
+func slowStartLoad(b *backends.Backend, load float64) float64 {
+	return (load + 1) / b.SlowStartFactor()
+}
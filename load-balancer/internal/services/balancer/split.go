@@ -0,0 +1,249 @@
+package balancer
+
+import (
+	"crypto/hmac"
+	"errors"
+	"fmt"
+	"hash/fnv"
+	"math/rand/v2"
+	"net"
+	"net/http"
+	"strconv"
+	"strings"
+	"sync/atomic"
+	"time"
+
+	"github.com/mirskow/load-balancer/internal/config"
+)
+
+const (
+	SplitStickyCookie = "cookie"
+	SplitStickyIP     = "ip"
+)
+
+const (
+	defaultSplitCookieName = "lb_variant"
+	defaultSplitCookieTTL  = 24 * time.Hour
+)
+
+// trafficSplit делит трафик маршрута между пулами в заданных пропорциях (например, 95% stable и 5% canary).
+// Веса можно менять во время работы, состав пулов - нет.
+//
+// Cookie варианта имеет вид <имя пула>.<время истечения>.<подпись> и подписывается тем же ключом,
+// что и cookie привязки к бэкенду, поэтому клиент не может сам выбрать себе вариант.
+// Заголовок-переопределение принимается только от клиентов из доверенных сетей.
+type trafficSplit struct {
+	names          []string
+	pools          []*LoadBalancer
+	weights        atomic.Pointer[[]int]
+	sticky         string // "", cookie или ip
+	cookieName     string
+	cookieTTL      time.Duration
+	key            []byte
+	overrideHeader string
+	overrideNets   []*net.IPNet
+}
+
+// newTrafficSplit создает разделение трафика между пулами. key - ключ подписи cookie варианта.
+func newTrafficSplit(cfg config.SplitConfig, pools map[string]*LoadBalancer, key string) (*trafficSplit, error) {
+	s := &trafficSplit{
+		names:          make([]string, 0, len(cfg.Pools)),
+		pools:          make([]*LoadBalancer, 0, len(cfg.Pools)),
+		sticky:         cfg.Sticky,
+		cookieName:     cfg.CookieName,
+		cookieTTL:      cfg.CookieTTL,
+		key:            []byte(key),
+		overrideHeader: cfg.OverrideHeader,
+	}
+
+	switch s.sticky {
+	case "", SplitStickyIP:
+	case SplitStickyCookie:
+		if key == "" {
+			return nil, errors.New("split cookie stickiness requires a sticky session signing key")
+		}
+	default:
+		return nil, fmt.Errorf("unknown split stickiness %q (available: %s, %s)", s.sticky, SplitStickyCookie, SplitStickyIP)
+	}
+
+	if s.overrideHeader != "" && len(cfg.OverrideTrustedNets) == 0 {
+		return nil, errors.New("split override header requires trusted networks")
+	}
+	for _, cidr := range cfg.OverrideTrustedNets {
+		_, ipNet, err := net.ParseCIDR(cidr)
+		if err != nil {
+			return nil, fmt.Errorf("override trusted network: %w", err)
+		}
+		s.overrideNets = append(s.overrideNets, ipNet)
+	}
+
+	if s.cookieName == "" {
+		s.cookieName = defaultSplitCookieName
+	}
+	if s.cookieTTL <= 0 {
+		s.cookieTTL = defaultSplitCookieTTL
+	}
+
+	for _, variant := range cfg.Pools {
+		pool, ok := pools[variant.Pool]
+		if !ok {
+			return nil, fmt.Errorf("unknown pool %q", variant.Pool)
+		}
+		if s.index(variant.Pool) >= 0 {
+			return nil, fmt.Errorf("pool %q is listed twice", variant.Pool)
+		}
+
+		s.names = append(s.names, variant.Pool)
+		s.pools = append(s.pools, pool)
+	}
+
+	if err := s.setWeights(cfg); err != nil {
+		return nil, err
+	}
+
+	return s, nil
+}
+
+// setWeights атомарно заменяет веса пулов. Список пулов должен совпадать с исходным.
+func (s *trafficSplit) setWeights(cfg config.SplitConfig) error {
+	weights := make([]int, len(s.names))
+	seen := make([]bool, len(s.names))
+	total := 0
+
+	for _, variant := range cfg.Pools {
+		i := s.index(variant.Pool)
+		if i < 0 || seen[i] {
+			return fmt.Errorf("split pools cannot be changed at runtime: %q", variant.Pool)
+		}
+		if variant.Weight < 0 {
+			return fmt.Errorf("pool %q: negative weight %d", variant.Pool, variant.Weight)
+		}
+
+		seen[i] = true
+		weights[i] = variant.Weight
+		total += variant.Weight
+	}
+
+	if len(cfg.Pools) != len(s.names) {
+		return fmt.Errorf("split pools cannot be changed at runtime")
+	}
+	if total == 0 {
+		return fmt.Errorf("split needs at least one pool with positive weight")
+	}
+
+	s.weights.Store(&weights)
+	return nil
+}
+
+// choose выбирает пул для запроса: по заголовку-переопределению от доверенного клиента, затем по cookie варианта
+// или хешу IP клиента, иначе случайно пропорционально весам.
+func (s *trafficSplit) choose(w http.ResponseWriter, r *http.Request) *LoadBalancer {
+	weights := *s.weights.Load()
+
+	// Переопределение работает и для пула с нулевым весом, чтобы можно было проверить канарейку до запуска.
+	if s.overrideHeader != "" && s.trusted(r) {
+		if i := s.index(r.Header.Get(s.overrideHeader)); i >= 0 {
+			return s.pools[i]
+		}
+	}
+
+	switch s.sticky {
+	case SplitStickyCookie:
+		if i := s.variantFor(r); i >= 0 && weights[i] > 0 {
+			return s.pools[i]
+		}
+
+		i := pickWeighted(weights, rand.IntN(sum(weights)))
+		payload := s.names[i] + "." + strconv.FormatInt(time.Now().Add(s.cookieTTL).Unix(), 10)
+		http.SetCookie(w, &http.Cookie{
+			Name:     s.cookieName,
+			Value:    payload + "." + signCookie(s.key, payload),
+			Path:     "/",
+			MaxAge:   int(s.cookieTTL.Seconds()),
+			HttpOnly: true,
+			SameSite: http.SameSiteLaxMode,
+		})
+		return s.pools[i]
+
+	case SplitStickyIP:
+		h := fnv.New32a()
+		h.Write([]byte(clientIPKey(r)))
+		return s.pools[pickWeighted(weights, int(h.Sum32()%uint32(sum(weights))))]
+
+	default:
+		return s.pools[pickWeighted(weights, rand.IntN(sum(weights)))]
+	}
+}
+
+// variantFor возвращает индекс пула из cookie варианта или -1, если cookie нет,
+// подпись не совпадает или срок cookie истек.
+func (s *trafficSplit) variantFor(r *http.Request) int {
+	cookie, err := r.Cookie(s.cookieName)
+	if err != nil {
+		return -1
+	}
+
+	// Имя пула может содержать точки, поэтому значение разбирается с конца.
+	dot := strings.LastIndexByte(cookie.Value, '.')
+	if dot < 0 {
+		return -1
+	}
+	payload, signature := cookie.Value[:dot], cookie.Value[dot+1:]
+	if !hmac.Equal([]byte(signature), []byte(signCookie(s.key, payload))) {
+		return -1
+	}
+
+	dot = strings.LastIndexByte(payload, '.')
+	if dot < 0 {
+		return -1
+	}
+	expiresAt, err := strconv.ParseInt(payload[dot+1:], 10, 64)
+	if err != nil || time.Now().Unix() > expiresAt {
+		return -1
+	}
+
+	return s.index(payload[:dot])
+}
+
+// trusted сообщает, пришел ли запрос из сети, которой разрешено переопределять вариант.
+func (s *trafficSplit) trusted(r *http.Request) bool {
+	ip := net.ParseIP(clientIPKey(r))
+	if ip == nil {
+		return false
+	}
+
+	for _, ipNet := range s.overrideNets {
+		if ipNet.Contains(ip) {
+			return true
+		}
+	}
+	return false
+}
+
+func (s *trafficSplit) index(name string) int {
+	for i, n := range s.names {
+		if n == name {
+			return i
+		}
+	}
+	return -1
+}
+
+// pickWeighted возвращает индекс пула, в диапазон весов которого попадает point из [0, sum(weights)).
+func pickWeighted(weights []int, point int) int {
+	for i, weight := range weights {
+		if point < weight {
+			return i
+		}
+		point -= weight
+	}
+	return len(weights) - 1
+}
+
+func sum(values []int) int {
+	total := 0
+	for _, v := range values {
+		total += v
+	}
+	return total
+}
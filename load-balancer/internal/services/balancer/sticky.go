@@ -0,0 +1,166 @@
+package balancer
+
+import (
+	"crypto/hmac"
+	"crypto/sha256"
+	"encoding/base64"
+	"encoding/hex"
+	"errors"
+	"net/http"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/mirskow/load-balancer/internal/backends"
+	"github.com/mirskow/load-balancer/internal/config"
+)
+
+const (
+	defaultStickyCookieName = "lb_session"
+	defaultStickyTTL        = time.Hour
+)
+
+// stickySessions реализует привязку клиента к бэкенду через подписанную cookie.
+//
+// Значение cookie имеет вид <id бэкенда>.<время истечения>.<подпись>, где подпись - HMAC-SHA256
+// от первых двух частей. Поэтому клиент не может ни подменить бэкенд, ни продлить срок привязки.
+type stickySessions struct {
+	cookieName string
+	ttl        time.Duration
+	key        []byte
+	byID       map[string]*backends.Backend
+	ids        map[*backends.Backend]string
+}
+
+func newStickySessions(cfg config.StickySessionConfig, pool []*backends.Backend) (*stickySessions, error) {
+	if cfg.SigningKey == "" {
+		return nil, errors.New("sticky sessions require a signing key")
+	}
+
+	s := &stickySessions{
+		cookieName: cfg.CookieName,
+		ttl:        cfg.TTL,
+		key:        []byte(cfg.SigningKey),
+		byID:       make(map[string]*backends.Backend, len(pool)),
+		ids:        make(map[*backends.Backend]string, len(pool)),
+	}
+
+	if s.cookieName == "" {
+		s.cookieName = defaultStickyCookieName
+	}
+	if s.ttl <= 0 {
+		s.ttl = defaultStickyTTL
+	}
+
+	for _, b := range pool {
+		sum := sha256.Sum256([]byte(b.URL.String()))
+		id := hex.EncodeToString(sum[:8])
+		s.byID[id] = b
+		s.ids[b] = id
+	}
+
+	return s, nil
+}
+
+// poolStickyCookieName возвращает имя cookie привязки для именованного пула: base (или имя по умолчанию)
+// с суффиксом из имени пула. Символы, недопустимые в имени cookie, заменяются на "_".
+func poolStickyCookieName(base, pool string) string {
+	if base == "" {
+		base = defaultStickyCookieName
+	}
+
+	suffix := strings.Map(func(r rune) rune {
+		switch {
+		case r >= 'a' && r <= 'z', r >= 'A' && r <= 'Z', r >= '0' && r <= '9', r == '-', r == '_', r == '.':
+			return r
+		default:
+			return '_'
+		}
+	}, pool)
+
+	return base + "_" + suffix
+}
+
+// backendFor возвращает живой бэкенд, к которому привязан клиент, или nil,
+// если cookie нет, она повреждена, просрочена или указывает на недоступный бэкенд.
+func (s *stickySessions) backendFor(r *http.Request, alive []*backends.Backend) *backends.Backend {
+	cookie, err := r.Cookie(s.cookieName)
+	if err != nil {
+		return nil
+	}
+
+	parts := strings.Split(cookie.Value, ".")
+	if len(parts) != 3 {
+		return nil
+	}
+
+	id, expires, signature := parts[0], parts[1], parts[2]
+	if !hmac.Equal([]byte(signature), []byte(s.sign(id+"."+expires))) {
+		return nil
+	}
+
+	expiresAt, err := strconv.ParseInt(expires, 10, 64)
+	if err != nil || time.Now().Unix() > expiresAt {
+		return nil
+	}
+
+	pinned, ok := s.byID[id]
+	if !ok {
+		return nil
+	}
+
+	for _, b := range alive {
+		if b == pinned {
+			return b
+		}
+	}
+
+	return nil
+}
+
+// pin добавляет в ответ cookie, привязывающую клиента к бэкенду.
+func (s *stickySessions) pin(w http.ResponseWriter, b *backends.Backend) {
+	id, ok := s.ids[b]
+	if !ok {
+		return
+	}
+
+	payload := id + "." + strconv.FormatInt(time.Now().Add(s.ttl).Unix(), 10)
+
+	http.SetCookie(w, &http.Cookie{
+		Name:     s.cookieName,
+		Value:    payload + "." + s.sign(payload),
+		Path:     "/",
+		MaxAge:   int(s.ttl.Seconds()),
+		HttpOnly: true,
+		SameSite: http.SameSiteLaxMode,
+	})
+}
+
+// unpin убирает из ответа cookie привязки, добавленную ранее, не трогая остальные cookie.
+func (s *stickySessions) unpin(w http.ResponseWriter) {
+	cookies := w.Header().Values("Set-Cookie")
+	kept := make([]string, 0, len(cookies))
+	for _, c := range cookies {
+		if !strings.HasPrefix(c, s.cookieName+"=") {
+			kept = append(kept, c)
+		}
+	}
+
+	if len(kept) == 0 {
+		w.Header().Del("Set-Cookie")
+		return
+	}
+	w.Header()["Set-Cookie"] = kept
+}
+
+func (s *stickySessions) sign(payload string) string {
+	return signCookie(s.key, payload)
+}
+
+// signCookie возвращает подпись HMAC-SHA256 значения cookie ключом key.
+func signCookie(key []byte, payload string) string {
+	mac := hmac.New(sha256.New, key)
+	mac.Write([]byte(payload))
+	return base64.RawURLEncoding.EncodeToString(mac.Sum(nil))
+}
@@ -0,0 +1,152 @@
+package balancer
+
+import (
+	"context"
+	"crypto/tls"
+	"errors"
+	"io"
+	"net"
+	"net/http"
+	"net/url"
+	"time"
+
+	"golang.org/x/net/http2"
+
+	"github.com/mirskow/load-balancer/internal/config"
+)
+
+const (
+	defaultDialTimeout         = 30 * time.Second
+	defaultKeepAlive           = 30 * time.Second
+	defaultIdleConnTimeout     = 90 * time.Second
+	defaultMaxIdleConnsPerHost = 100
+)
+
+// upstreamTransport содержит транспорты для запросов к бэкендам пула.
+// Один пул соединений используется всеми бэкендами, лимиты задаются на каждый хост.
+type upstreamTransport struct {
+	http *http.Transport
+	h2c  *http2.Transport // nil, если HTTP/2 без TLS (h2c) отключен
+
+	responseHeaderTimeout time.Duration // http2.Transport не поддерживает его сам, см. h2cRoundTripper
+}
+
+// newUpstreamTransport создает транспорт по настройкам из конфигурации.
+// Незаданные значения заменяются значениями по умолчанию, как у http.DefaultTransport,
+// кроме числа простаивающих соединений на хост: по умолчанию их 100, а не 2.
+func newUpstreamTransport(cfg config.TransportConfig) *upstreamTransport {
+	dialer := &net.Dialer{
+		Timeout:   cfg.DialTimeout,
+		KeepAlive: cfg.KeepAlive,
+	}
+	if dialer.Timeout <= 0 {
+		dialer.Timeout = defaultDialTimeout
+	}
+	if dialer.KeepAlive == 0 {
+		dialer.KeepAlive = defaultKeepAlive
+	}
+
+	idleConnTimeout := cfg.IdleConnTimeout
+	if idleConnTimeout <= 0 {
+		idleConnTimeout = defaultIdleConnTimeout
+	}
+
+	maxIdleConnsPerHost := cfg.MaxIdleConnsPerHost
+	if maxIdleConnsPerHost <= 0 {
+		maxIdleConnsPerHost = defaultMaxIdleConnsPerHost
+	}
+
+	t := &upstreamTransport{
+		http: &http.Transport{
+			Proxy:                 http.ProxyFromEnvironment,
+			DialContext:           dialer.DialContext,
+			ForceAttemptHTTP2:     cfg.HTTP2,
+			MaxIdleConnsPerHost:   maxIdleConnsPerHost,
+			MaxConnsPerHost:       cfg.MaxConnsPerHost,
+			IdleConnTimeout:       idleConnTimeout,
+			ResponseHeaderTimeout: cfg.ResponseHeaderTimeout,
+			TLSHandshakeTimeout:   10 * time.Second,
+			ExpectContinueTimeout: time.Second,
+		},
+	}
+
+	if cfg.H2C {
+		// h2c: HTTP/2 с предварительным знанием (prior knowledge) поверх обычного TCP.
+		// Если число соединений ограничено, все запросы к хосту идут через одно соединение
+		// и ждут свободного потока, а не открывают новые соединения.
+		t.h2c = &http2.Transport{
+			AllowHTTP: true,
+			DialTLSContext: func(ctx context.Context, network, addr string, _ *tls.Config) (net.Conn, error) {
+				return dialer.DialContext(ctx, network, addr)
+			},
+			ReadIdleTimeout:            dialer.KeepAlive,
+			IdleConnTimeout:            idleConnTimeout,
+			StrictMaxConcurrentStreams: cfg.MaxConnsPerHost > 0,
+		}
+		t.responseHeaderTimeout = cfg.ResponseHeaderTimeout
+	}
+
+	return t
+}
+
+// forURL возвращает транспорт для бэкенда: h2c для http-адресов, если он включен, иначе общий HTTP транспорт.
+// Через h2c не проксируются запросы на смену протокола (WebSocket) - их HTTP/2 не поддерживает.
+func (t *upstreamTransport) forURL(u *url.URL) http.RoundTripper {
+	if t.h2c != nil && u.Scheme == "http" {
+		return &h2cRoundTripper{h2c: t.h2c, fallback: t.http, headerTimeout: t.responseHeaderTimeout}
+	}
+	return t.http
+}
+
+// h2cRoundTripper отправляет запросы по h2c, а запросы на смену протокола - по HTTP/1.1.
+// Если задан headerTimeout, запрос отменяется, когда заголовки ответа не пришли за это время,
+// как ResponseHeaderTimeout у http.Transport.
+type h2cRoundTripper struct {
+	h2c           *http2.Transport
+	fallback      http.RoundTripper
+	headerTimeout time.Duration
+}
+
+// errH2CHeaderTimeout повторяет ошибку http.Transport при истечении ResponseHeaderTimeout.
+var errH2CHeaderTimeout = errors.New("net/http: timeout awaiting response headers")
+
+func (rt *h2cRoundTripper) RoundTrip(r *http.Request) (*http.Response, error) {
+	if r.Header.Get("Upgrade") != "" {
+		return rt.fallback.RoundTrip(r)
+	}
+	if rt.headerTimeout <= 0 {
+		return rt.h2c.RoundTrip(r)
+	}
+
+	ctx, cancel := context.WithCancel(r.Context())
+	timer := time.AfterFunc(rt.headerTimeout, cancel)
+
+	resp, err := rt.h2c.RoundTrip(r.WithContext(ctx))
+	if !timer.Stop() {
+		cancel()
+		if err == nil {
+			resp.Body.Close()
+		}
+		return nil, errH2CHeaderTimeout
+	}
+	if err != nil {
+		cancel()
+		return nil, err
+	}
+
+	// Тело ответа читается без таймаута, контекст освобождается при его закрытии.
+	resp.Body = &cancelOnClose{ReadCloser: resp.Body, cancel: cancel}
+	return resp, nil
+}
+
+// cancelOnClose отменяет контекст запроса при закрытии тела ответа.
+type cancelOnClose struct {
+	io.ReadCloser
+	cancel context.CancelFunc
+}
+
+func (b *cancelOnClose) Close() error {
+	err := b.ReadCloser.Close()
+	b.cancel()
+	return err
+}
@@ -0,0 +1,353 @@
+package balancer
+
+import (
+	"context"
+	"encoding/binary"
+	"errors"
+	"io"
+	"log"
+	"math/rand/v2"
+	"net/http"
+	"sync"
+	"sync/atomic"
+	"time"
+
+	"golang.org/x/net/http/httpguts"
+
+	"github.com/mirskow/load-balancer/internal/backends"
+	"github.com/mirskow/load-balancer/internal/config"
+	"github.com/mirskow/load-balancer/internal/requestid"
+)
+
+const (
+	defaultUpgradeIdleTimeout = 5 * time.Minute
+	upgradeCheckInterval      = time.Second
+	minUpgradeCheckInterval   = 10 * time.Millisecond
+
+	// upgradeCloseGrace - сколько ждать, пока бэкенд завершит WebSocket после кадра Close,
+	// прежде чем закрыть соединение принудительно.
+	upgradeCloseGrace = 5 * time.Second
+
+	// wsCloseGoingAway - код закрытия WebSocket 1001 (RFC 6455, раздел 7.4.1): сервер уходит.
+	wsCloseGoingAway = 1001
+	// wsOpClose - код операции кадра Close.
+	wsOpClose = 0x8
+)
+
+// isUpgrade сообщает, запрашивает ли клиент смену протокола (WebSocket, h2c и т.п.).
+func isUpgrade(r *http.Request) bool {
+	return r.Header.Get("Upgrade") != "" && httpguts.HeaderValuesContainsToken(r.Header["Connection"], "Upgrade")
+}
+
+// clearDeadlines снимает таймауты чтения и записи http.Server с клиентского соединения.
+// После смены протокола соединение живет долго, и его время жизни ограничивает upgradeTracker.
+func clearDeadlines(w http.ResponseWriter, r *http.Request) {
+	rc := http.NewResponseController(w)
+
+	err := errors.Join(rc.SetReadDeadline(time.Time{}), rc.SetWriteDeadline(time.Time{}))
+	if err != nil && !errors.Is(err, http.ErrNotSupported) {
+		log.Printf("[BALANCER - Upgrade] Error clearing connection deadlines: %v%s\n", err, requestid.Tag(r.Context()))
+	}
+}
+
+// upgradeTracker учитывает соединения после смены протокола и закрывает их, если соединение простаивает
+// дольше idleTimeout, живет дольше maxLifetime, бэкенд выведен из ротации или балансировщик останавливается.
+type upgradeTracker struct {
+	idleTimeout time.Duration
+	maxLifetime time.Duration // 0 - без ограничения
+
+	// drainReason возвращает причину закрыть соединения с бэкендом или "", если бэкенд в ротации.
+	drainReason func(*backends.Backend) string
+
+	mu    sync.Mutex
+	conns map[*upgradedConn]struct{}
+}
+
+func newUpgradeTracker(cfg config.UpgradeConfig, drainReason func(*backends.Backend) string) *upgradeTracker {
+	t := &upgradeTracker{
+		idleTimeout: cfg.IdleTimeout,
+		maxLifetime: cfg.MaxLifetime,
+		drainReason: drainReason,
+		conns:       make(map[*upgradedConn]struct{}),
+	}
+
+	if t.idleTimeout <= 0 {
+		t.idleTimeout = defaultUpgradeIdleTimeout
+	}
+
+	return t
+}
+
+// track оборачивает соединение с бэкендом, полученное в ответе 101 Switching Protocols.
+// Для WebSocket (websocket = true) соединение закрывается штатно, кадром Close.
+func (t *upgradeTracker) track(backend *backends.Backend, conn io.ReadWriteCloser, websocket bool) io.ReadWriteCloser {
+	uc := &upgradedConn{ReadWriteCloser: conn, tracker: t, backend: backend, start: time.Now()}
+	if websocket {
+		uc.frames = &wsFrameTracker{}
+	}
+	uc.touch()
+
+	t.mu.Lock()
+	t.conns[uc] = struct{}{}
+	t.mu.Unlock()
+
+	backend.IncUpgradedConnections()
+
+	return uc
+}
+
+// checkInterval возвращает период проверки соединений: не реже раза в секунду
+// и не реже четверти самого короткого таймаута.
+func (t *upgradeTracker) checkInterval() time.Duration {
+	interval := min(upgradeCheckInterval, t.idleTimeout/4)
+	if t.maxLifetime > 0 {
+		interval = min(interval, t.maxLifetime/4)
+	}
+	return max(interval, minUpgradeCheckInterval)
+}
+
+// run периодически закрывает простаивающие соединения и соединения с бэкендами, выведенными из ротации.
+// При остановке балансировщика закрывает все соединения.
+func (t *upgradeTracker) run(ctx context.Context) {
+	ticker := time.NewTicker(t.checkInterval())
+	defer ticker.Stop()
+
+	for {
+		select {
+		case <-ctx.Done():
+			for _, uc := range t.snapshot() {
+				uc.drain("balancer is shutting down")
+			}
+			return
+		case now := <-ticker.C:
+			for _, uc := range t.snapshot() {
+				if reason := t.drainReason(uc.backend); reason != "" {
+					uc.drain(reason)
+					continue
+				}
+
+				switch {
+				case now.Sub(uc.lastActivity()) > t.idleTimeout:
+					uc.drain("idle timeout")
+				case t.maxLifetime > 0 && now.Sub(uc.start) > t.maxLifetime:
+					uc.drain("max lifetime reached")
+				}
+			}
+		}
+	}
+}
+
+func (t *upgradeTracker) snapshot() []*upgradedConn {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+
+	conns := make([]*upgradedConn, 0, len(t.conns))
+	for uc := range t.conns {
+		conns = append(conns, uc)
+	}
+	return conns
+}
+
+// upgradedConn - соединение с бэкендом после смены протокола. Запоминает время последней активности
+// в любом направлении. Закрытие соединения с бэкендом завершает и клиентское соединение в ReverseProxy.
+//
+// WebSocket закрывается штатно: на границе кадров в сторону бэкенда отправляется кадр Close
+// с кодом 1001, дальнейшие данные клиента отбрасываются, а бэкенд отвечает клиенту своим кадром Close
+// и закрывает соединение. Если бэкенд не сделал этого за upgradeCloseGrace, соединение закрывается принудительно.
+type upgradedConn struct {
+	io.ReadWriteCloser
+	tracker  *upgradeTracker
+	backend  *backends.Backend
+	start    time.Time
+	activity atomic.Int64 // время последней активности (UnixNano)
+	once     sync.Once
+
+	drainStarted atomic.Bool
+
+	writeMu   sync.Mutex
+	frames    *wsFrameTracker // nil, если соединение не WebSocket
+	draining  bool            // решено закрыть соединение, кадр Close ждет границы кадра
+	closeSent bool            // кадр Close отправлен бэкенду
+}
+
+func (uc *upgradedConn) touch() {
+	uc.activity.Store(time.Now().UnixNano())
+}
+
+func (uc *upgradedConn) lastActivity() time.Time {
+	return time.Unix(0, uc.activity.Load())
+}
+
+func (uc *upgradedConn) Read(p []byte) (int, error) {
+	n, err := uc.ReadWriteCloser.Read(p)
+	if n > 0 {
+		uc.touch()
+	}
+	return n, err
+}
+
+// Write передает бэкенду данные клиента. Для WebSocket отслеживает границы кадров,
+// чтобы кадр Close не оказался внутри кадра клиента.
+func (uc *upgradedConn) Write(p []byte) (int, error) {
+	uc.writeMu.Lock()
+	defer uc.writeMu.Unlock()
+
+	if uc.frames == nil {
+		n, err := uc.ReadWriteCloser.Write(p)
+		if n > 0 {
+			uc.touch()
+		}
+		return n, err
+	}
+
+	if uc.closeSent {
+		return len(p), nil
+	}
+
+	for written := 0; written < len(p); {
+		k, boundary := uc.frames.advance(p[written:])
+		n, err := uc.ReadWriteCloser.Write(p[written : written+k])
+		written += n
+		if n > 0 {
+			uc.touch()
+		}
+		if err != nil {
+			return written, err
+		}
+
+		if boundary && uc.draining {
+			uc.sendClose()
+			return len(p), nil
+		}
+	}
+
+	return len(p), nil
+}
+
+func (uc *upgradedConn) Close() error {
+	var err error
+	uc.once.Do(func() {
+		uc.tracker.mu.Lock()
+		delete(uc.tracker.conns, uc)
+		uc.tracker.mu.Unlock()
+
+		uc.backend.DecUpgradedConnections()
+		err = uc.ReadWriteCloser.Close()
+	})
+	return err
+}
+
+// drain начинает закрытие соединения. WebSocket закрывается кадром Close, остальные протоколы - сразу.
+// Не блокируется, даже если запись в соединение зависла.
+func (uc *upgradedConn) drain(reason string) {
+	if !uc.drainStarted.CompareAndSwap(false, true) {
+		return
+	}
+
+	if uc.frames == nil {
+		log.Printf("[BALANCER - Upgrade] Closing upgraded connection to %s: %s\n", uc.backend.URL, reason)
+		uc.Close()
+		return
+	}
+
+	log.Printf("[BALANCER - Upgrade] Closing WebSocket connection to %s: %s\n", uc.backend.URL, reason)
+	time.AfterFunc(upgradeCloseGrace, func() { uc.Close() })
+
+	go func() {
+		uc.writeMu.Lock()
+		defer uc.writeMu.Unlock()
+
+		uc.draining = true
+		if uc.frames.atBoundary() {
+			uc.sendClose()
+		}
+	}()
+}
+
+// sendClose отправляет бэкенду маскированный кадр Close с кодом 1001. Вызывается под writeMu.
+func (uc *upgradedConn) sendClose() {
+	uc.closeSent = true
+
+	var mask [4]byte
+	binary.BigEndian.PutUint32(mask[:], rand.Uint32())
+
+	frame := []byte{0x80 | wsOpClose, 0x80 | 2, mask[0], mask[1], mask[2], mask[3], wsCloseGoingAway >> 8, wsCloseGoingAway & 0xff}
+	for i := range frame[6:] {
+		frame[6+i] ^= mask[i%4]
+	}
+
+	if _, err := uc.ReadWriteCloser.Write(frame); err != nil {
+		uc.Close()
+	}
+}
+
+// wsFrameTracker отслеживает границы кадров WebSocket (RFC 6455, раздел 5.2) в потоке байтов.
+type wsFrameTracker struct {
+	header    [14]byte
+	headerLen int    // прочитано байтов заголовка текущего кадра
+	remaining uint64 // осталось байтов полезной нагрузки текущего кадра
+}
+
+func (f *wsFrameTracker) atBoundary() bool {
+	return f.headerLen == 0 && f.remaining == 0
+}
+
+// advance учитывает байты из p до ближайшей границы кадра включительно. Возвращает число учтенных байтов
+// и true, если после них кадр закончился.
+func (f *wsFrameTracker) advance(p []byte) (n int, boundary bool) {
+	for n < len(p) {
+		if f.remaining > 0 {
+			k := min(uint64(len(p)-n), f.remaining)
+			n += int(k)
+			f.remaining -= k
+			if f.remaining == 0 {
+				return n, true
+			}
+			continue
+		}
+
+		f.header[f.headerLen] = p[n]
+		f.headerLen++
+		n++
+
+		if size, ok := f.headerSize(); ok && f.headerLen == size {
+			f.remaining = f.payloadLen()
+			f.headerLen = 0
+			if f.remaining == 0 {
+				return n, true
+			}
+		}
+	}
+
+	return n, false
+}
+
+// headerSize возвращает полный размер заголовка текущего кадра, если прочитаны его первые два байта.
+func (f *wsFrameTracker) headerSize() (int, bool) {
+	if f.headerLen < 2 {
+		return 0, false
+	}
+
+	size := 2
+	switch f.header[1] & 0x7f {
+	case 126:
+		size += 2
+	case 127:
+		size += 8
+	}
+	if f.header[1]&0x80 != 0 {
+		size += 4
+	}
+	return size, true
+}
+
+func (f *wsFrameTracker) payloadLen() uint64 {
+	switch n := f.header[1] & 0x7f; n {
+	case 126:
+		return uint64(binary.BigEndian.Uint16(f.header[2:4]))
+	case 127:
+		return binary.BigEndian.Uint64(f.header[2:10])
+	default:
+		return uint64(n)
+	}
+}
@@ -0,0 +1,75 @@
+// Реализация стратегии балансировки нагрузки Smooth Weighted Round Robin (как в nginx).
+//
+// Стратегия распределяет запросы пропорционально весам бэкендов, при этом "размазывает"
+// запросы к тяжелым бэкендам по всему циклу, а не отдает их подряд.
+// Например, для весов {5, 1, 1} последовательность будет a a b a c a a, а не a a a a a b c.
+//
+// Особенности реализации:
+//   - На каждом шаге текущий вес каждого бэкенда увеличивается на его вес,
+//     выбирается бэкенд с максимальным текущим весом, и из его текущего веса вычитается сумма весов.
+//   - Текущие веса хранятся в стратегии и защищены мьютексом. Текущие веса бэкендов, выбывших
+//     из набора живых, удаляются: вернувшийся бэкенд начинает с нуля, а не с устаревшего веса.
+//   - Используется эффективный вес бэкенда, который учитывает плавный ввод в ротацию (slow start).
+//   - Если все бэкенды недоступны - возвращается nil.
+package balancer
+
+import (
+	"slices"
+	"sync"
+
+	"github.com/mirskow/load-balancer/internal/backends"
+)
+
+type WeightedRoundRobin struct {
+	mu             sync.Mutex
+	currentWeights map[*backends.Backend]float64
+}
+
+// NewWeightedRoundRobin создает новый экземпляр WeightedRoundRobin.
+func NewWeightedRoundRobin() *WeightedRoundRobin {
+	return &WeightedRoundRobin{
+		currentWeights: make(map[*backends.Backend]float64),
+	}
+}
+
+// NextBackend выбирает следующий живой бэкенд согласно весам.
+func (wrr *WeightedRoundRobin) NextBackend(pool []*backends.Backend) *backends.Backend {
+	wrr.mu.Lock()
+	defer wrr.mu.Unlock()
+
+	var (
+		best        *backends.Backend
+		totalWeight float64
+		alive       int
+	)
+
+	for _, b := range pool {
+		if !b.IsAlive() {
+			continue
+		}
+		alive++
+
+		weight := b.EffectiveWeight()
+		wrr.currentWeights[b] += weight
+		totalWeight += weight
+
+		if best == nil || wrr.currentWeights[b] > wrr.currentWeights[best] {
+			best = b
+		}
+	}
+
+	if best != nil {
+		wrr.currentWeights[best] -= totalWeight
+	}
+
+	// Выбывшие бэкенды удаляются, чтобы вернувшийся бэкенд не получил запросы по устаревшему весу.
+	if len(wrr.currentWeights) > alive {
+		for b := range wrr.currentWeights {
+			if !b.IsAlive() || !slices.Contains(pool, b) {
+				delete(wrr.currentWeights, b)
+			}
+		}
+	}
+
+	return best
+}
@@ -14,6 +14,7 @@ import (
 
 	"github.com/mirskow/load-balancer/internal/config"
 	"github.com/mirskow/load-balancer/internal/repository"
+	"github.com/mirskow/load-balancer/internal/requestid"
 )
 
 //go:embed allow_script.lua
@@ -57,7 +58,7 @@ func (t *TokenBucket) Allow(ctx context.Context, ip string) bool {
 	result, err := t.client.Eval(ctx, allowScript, []string{key, configKey}, now, t.ttl, t.defaultCapacity, t.defaultRate)
 
 	if err != nil {
-		log.Println("[RATE-LIMITER] allow errors:", err)
+		log.Printf("[RATE-LIMITER] allow errors: %v%s\n", err, requestid.Tag(ctx))
 		return false
 	}
 
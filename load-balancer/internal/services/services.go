@@ -1,5 +1,6 @@
 // Package services агрегирует и инициализирует основные сервисы приложения, такие как
-// ограничение скорости запросов (rate limiter) и балансировщик нагрузки (load balancer).
+// ограничение скорости запросов (rate limiter), балансировщик нагрузки (load balancer)
+// и шина событий о смене состояния бэкендов.
 //
 // Основные возможности пакета:
 //   - Определяет интерфейсы для сервисов RateLimiter и Balancer, упрощающие тестирование и масштабирование.
@@ -12,6 +13,7 @@ import (
 	"net/http"
 
 	"github.com/mirskow/load-balancer/internal/config"
+	"github.com/mirskow/load-balancer/internal/events"
 	"github.com/mirskow/load-balancer/internal/repository"
 	"github.com/mirskow/load-balancer/internal/services/balancer"
 	ratelimiter "github.com/mirskow/load-balancer/internal/services/rate-limiter"
@@ -28,11 +30,40 @@ type Balancer interface {
 type Services struct {
 	RateLimiter  RateLimiter
 	LoadBalancer Balancer
+	Events       *events.Bus
 }
 
-func NewServices(ctx context.Context, repo *repository.Repository, cfg config.Config) *Services {
+// UpdateTrafficSplit применяет новые веса разделения трафика между пулами без перезапуска.
+func (s *Services) UpdateTrafficSplit(routes []config.RouteConfig) error {
+	router, ok := s.LoadBalancer.(*balancer.Router)
+	if !ok {
+		return nil
+	}
+
+	return router.UpdateSplitWeights(routes)
+}
+
+func NewServices(ctx context.Context, repo *repository.Repository, cfg config.Config) (*Services, error) {
+	bus := events.NewBus()
+
+	if cfg.Events.Webhook.URL != "" {
+		sink := events.NewWebhookSink(cfg.Events.Webhook)
+		ch, unsubscribe := bus.Subscribe(events.WebhookBuffer)
+
+		go func() {
+			defer unsubscribe()
+			sink.Run(ctx, ch)
+		}()
+	}
+
+	loadBalancer, err := balancer.NewRouter(ctx, cfg.Balancer, bus)
+	if err != nil {
+		return nil, err
+	}
+
 	return &Services{
 		RateLimiter:  ratelimiter.NewTokenBucket(ctx, repo.RateLimiterRepository, cfg.Limiter),
-		LoadBalancer: balancer.NewLoadBalancer(ctx, cfg.Balancer),
-	}
+		LoadBalancer: loadBalancer,
+		Events:       bus,
+	}, nil
 }
@@ -0,0 +1,219 @@
+package tests
+
+import (
+	"context"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/mirskow/load-balancer/internal/config"
+	"github.com/mirskow/load-balancer/internal/services/balancer"
+)
+
+func TestCircuitBreakerOpensAndRecovers(t *testing.T) {
+	lb, statuses := newStatusBalancer(t, 1, func(cfg *config.BalancerConfig) {
+		cfg.CircuitBreaker = config.CircuitBreakerConfig{
+			Enabled:          true,
+			MinRequests:      4,
+			ErrorRatePercent: 50,
+			OpenDuration:     200 * time.Millisecond,
+			HalfOpenRequests: 2,
+		}
+	})
+	statuses[0].Store(http.StatusInternalServerError)
+
+	// closed -> open: 4 ошибки из 4 запросов.
+	if hits := routeN(lb, 4); hits["backend0"] != 4 {
+		t.Fatalf("closed breaker rejected requests: %v", hits)
+	}
+	if hits := routeN(lb, 3); hits["backend0"] != 0 {
+		t.Fatalf("breaker did not open after error rate threshold: %v", hits)
+	}
+
+	// open -> half-open -> open: пробный запрос с ошибкой снова открывает выключатель.
+	time.Sleep(250 * time.Millisecond)
+	if hits := routeN(lb, 3); hits["backend0"] != 1 {
+		t.Fatalf("expected a single failed probe in half-open state, got %v", hits)
+	}
+
+	// open -> half-open -> closed: успешные пробные запросы закрывают выключатель.
+	statuses[0].Store(http.StatusOK)
+	time.Sleep(250 * time.Millisecond)
+	if hits := routeN(lb, 5); hits["backend0"] != 5 {
+		t.Fatalf("breaker did not close after successful probes: %v", hits)
+	}
+}
+
+func TestCircuitBreakerWaitsForMinRequests(t *testing.T) {
+	lb, statuses := newStatusBalancer(t, 1, func(cfg *config.BalancerConfig) {
+		cfg.CircuitBreaker = config.CircuitBreakerConfig{
+			Enabled:          true,
+			MinRequests:      5,
+			ErrorRatePercent: 50,
+			OpenDuration:     time.Hour,
+		}
+	})
+	statuses[0].Store(http.StatusInternalServerError)
+
+	// Пока запросов меньше minRequests, даже 100% ошибок не открывают выключатель.
+	if hits := routeN(lb, 5); hits["backend0"] != 5 {
+		t.Fatalf("breaker opened before min requests: %v", hits)
+	}
+	if hits := routeN(lb, 1); hits["backend0"] != 0 {
+		t.Fatalf("breaker did not open after min requests: %v", hits)
+	}
+}
+
+func TestCircuitBreakerOpensOnSlowCalls(t *testing.T) {
+	var delay atomic.Int64
+	lb, _ := newTestBalancer(t, func(cfg *config.BalancerConfig) {
+		cfg.CircuitBreaker = config.CircuitBreakerConfig{
+			Enabled:             true,
+			MinRequests:         4,
+			SlowCallDuration:    50 * time.Millisecond,
+			SlowCallRatePercent: 50,
+			OpenDuration:        time.Hour,
+		}
+	}, http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		time.Sleep(time.Duration(delay.Load()))
+		w.Write([]byte("backend0"))
+	}))
+
+	// Один медленный ответ из четырех - ниже порога в 50%.
+	delay.Store(int64(80 * time.Millisecond))
+	routeN(lb, 1)
+	delay.Store(0)
+	if hits := routeN(lb, 4); hits["backend0"] != 4 {
+		t.Fatalf("breaker opened below slow call rate: %v", hits)
+	}
+
+	// Успешные, но медленные ответы открывают выключатель.
+	delay.Store(int64(80 * time.Millisecond))
+	routeN(lb, 4)
+	if hits := routeN(lb, 1); hits["backend0"] != 0 {
+		t.Fatalf("breaker did not open on slow calls: %v", hits)
+	}
+}
+
+func TestCircuitBreakerReleasesProbeOnAbortedResponse(t *testing.T) {
+	var truncate atomic.Bool
+	var status atomic.Int32
+	status.Store(http.StatusInternalServerError)
+
+	lb, _ := newTestBalancer(t, func(cfg *config.BalancerConfig) {
+		cfg.CircuitBreaker = config.CircuitBreakerConfig{
+			Enabled:          true,
+			MinRequests:      2,
+			ErrorRatePercent: 50,
+			OpenDuration:     100 * time.Millisecond,
+			HalfOpenRequests: 1,
+		}
+	}, http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if truncate.Load() {
+			// Обрыв соединения посреди тела: прокси прерывает обработчик паникой http.ErrAbortHandler.
+			w.Header().Set("Content-Length", "100")
+			w.Write([]byte("partial"))
+			w.(http.Flusher).Flush()
+			conn, _, _ := w.(http.Hijacker).Hijack()
+			conn.Close()
+			return
+		}
+		w.WriteHeader(int(status.Load()))
+		w.Write([]byte("backend0"))
+	}))
+
+	front := httptest.NewServer(http.HandlerFunc(lb.Route))
+	defer front.Close()
+
+	get := func() (string, error) {
+		resp, err := http.Get(front.URL)
+		if err != nil {
+			return "", err
+		}
+		defer resp.Body.Close()
+
+		var body [64]byte
+		n, _ := resp.Body.Read(body[:])
+		return string(body[:n]), nil
+	}
+
+	get()
+	get()
+	time.Sleep(150 * time.Millisecond)
+
+	// Единственный пробный запрос полуоткрытого выключателя обрывается.
+	truncate.Store(true)
+	get()
+
+	truncate.Store(false)
+	status.Store(http.StatusOK)
+	time.Sleep(150 * time.Millisecond)
+	if body, err := get(); err != nil || body != "backend0" {
+		t.Fatalf("backend stayed out of rotation after aborted probe: %q, %v", body, err)
+	}
+}
+
+func TestOpenBreakerDoesNotRemapOtherKeys(t *testing.T) {
+	lb, statuses := newStatusBalancer(t, 4, func(cfg *config.BalancerConfig) {
+		cfg.Strategy = "maglev"
+		cfg.Hash = config.HashConfig{Key: balancer.HashKeyHeader, Name: "X-User"}
+		cfg.CircuitBreaker = config.CircuitBreakerConfig{
+			Enabled:          true,
+			Window:           time.Second,
+			MinRequests:      2,
+			ErrorRatePercent: 50,
+			OpenDuration:     time.Minute,
+		}
+	})
+
+	route := func(user string) string {
+		rec := httptest.NewRecorder()
+		req := httptest.NewRequest(http.MethodGet, "/", nil)
+		req.Header.Set("X-User", user)
+		lb.Route(rec, req)
+		return rec.Body.String()
+	}
+
+	owners := make(map[string]string)
+	var failing string
+	for i := 0; i < 1000; i++ {
+		user := fmt.Sprintf("user-%d", i)
+		owners[user] = route(user)
+		if owners[user] == "backend0" {
+			failing = user
+		}
+	}
+
+	// Когда успешные запросы выходят из окна, открываем выключатель backend0 двумя ошибками.
+	time.Sleep(1100 * time.Millisecond)
+	statuses[0].Store(http.StatusInternalServerError)
+	route(failing)
+	route(failing)
+
+	// Ключи остальных бэкендов остаются на месте: таблица Maglev не перестраивается.
+	for user, owner := range owners {
+		got := route(user)
+		if owner == "backend0" {
+			if got == "backend0" {
+				t.Fatalf("%s routed to backend with open breaker", user)
+			}
+			continue
+		}
+		if got != owner {
+			t.Fatalf("%s moved from %s to %s after an unrelated breaker opened", user, owner, got)
+		}
+	}
+}
+
+func TestCircuitBreakerRejectsShortWindow(t *testing.T) {
+	cfg := config.BalancerConfig{
+		Backends:       []config.BackendConfig{{URL: "http://backend0", Weight: 1}},
+		CircuitBreaker: config.CircuitBreakerConfig{Enabled: true, Window: 5 * time.Nanosecond},
+	}
+	if _, err := balancer.NewLoadBalancer(context.Background(), cfg, nil); err == nil {
+		t.Fatal("expected error for circuit breaker window shorter than a second")
+	}
+}
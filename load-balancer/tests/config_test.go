@@ -0,0 +1,222 @@
+package tests
+
+import (
+	"context"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"reflect"
+	"testing"
+	"time"
+
+	"github.com/spf13/viper"
+
+	"github.com/mirskow/load-balancer/internal/config"
+	"github.com/mirskow/load-balancer/internal/services"
+	"github.com/mirskow/load-balancer/internal/services/balancer"
+)
+
+// writeConfig записывает config.yml в каталог dir.
+func writeConfig(t *testing.T, dir, content string) {
+	t.Helper()
+
+	if err := os.WriteFile(filepath.Join(dir, "config.yml"), []byte(content), 0o644); err != nil {
+		t.Fatal(err)
+	}
+}
+
+// loadConfig разбирает config.yml с заданным содержимым так же, как при запуске приложения.
+// Возвращает каталог с файлом, чтобы тест мог его изменить.
+func loadConfig(t *testing.T, content string) (*config.Config, string) {
+	t.Helper()
+
+	// viper хранит пути поиска и разобранный файл глобально.
+	viper.Reset()
+	t.Cleanup(viper.Reset)
+
+	dir := t.TempDir()
+	writeConfig(t, dir, content)
+
+	cfg, err := config.Init(dir)
+	if err != nil {
+		t.Fatalf("config.Init: %v", err)
+	}
+	return cfg, dir
+}
+
+func TestConfigReloadUpdatesTrafficSplit(t *testing.T) {
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+
+	servers := make(map[string]string)
+	for _, name := range []string{"stable", "canary"} {
+		srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			w.Write([]byte(name))
+		}))
+		t.Cleanup(srv.Close)
+		servers[name] = srv.URL
+	}
+
+	splitConfig := func(stable, canary int) string {
+		return fmt.Sprintf(`
+balancer:
+  healthCheckTime: 100
+  pools:
+    - name: stable
+      backends: [%s]
+    - name: canary
+      backends: [%s]
+  routes:
+    - pathPrefix: /
+      split:
+        pools:
+          - pool: stable
+            weight: %d
+          - pool: canary
+            weight: %d
+`, servers["stable"], servers["canary"], stable, canary)
+	}
+
+	cfg, dir := loadConfig(t, splitConfig(100, 0))
+
+	router, err := balancer.NewRouter(ctx, cfg.Balancer, nil)
+	if err != nil {
+		t.Fatalf("NewRouter: %v", err)
+	}
+	svc := &services.Services{LoadBalancer: router}
+
+	routeN := func(n int) map[string]int {
+		hits := make(map[string]int)
+		for i := 0; i < n; i++ {
+			rec := httptest.NewRecorder()
+			router.Route(rec, httptest.NewRequest(http.MethodGet, "/", nil))
+			hits[rec.Body.String()]++
+		}
+		return hits
+	}
+
+	if hits := routeN(100); hits["stable"] != 100 {
+		t.Fatalf("expected all traffic on stable before reload, got %v", hits)
+	}
+
+	reloaded := make(chan error, 1)
+	config.Watch(func(newCfg *config.Config) {
+		select {
+		case reloaded <- svc.UpdateTrafficSplit(newCfg.Balancer.Routes):
+		default:
+		}
+	})
+
+	writeConfig(t, dir, splitConfig(0, 100))
+
+	select {
+	case err := <-reloaded:
+		if err != nil {
+			t.Fatalf("UpdateTrafficSplit: %v", err)
+		}
+	case <-time.After(5 * time.Second):
+		t.Fatal("config change was not picked up")
+	}
+
+	if hits := routeN(100); hits["canary"] != 100 {
+		t.Fatalf("expected all traffic on canary after reload, got %v", hits)
+	}
+}
+
+func TestConfigPoolsInheritBalancerSettings(t *testing.T) {
+	cfg, _ := loadConfig(t, `
+balancer:
+  strategy: least-connections
+  stickySession:
+    enabled: true
+    cookieName: lb_session
+    signingKey: secret
+  healthCheck:
+    path: /health
+    timeout: 2s
+  circuitBreaker:
+    enabled: true
+    minRequests: 10
+  backends:
+    - http://default:8080
+  pools:
+    - name: api
+      strategy: maglev
+      healthCheck:
+        path: /healthz
+      backends:
+        - url: http://api:8081
+          weight: 3
+    - name: static
+      stickySession:
+        cookieName: static_session
+`)
+
+	if len(cfg.Balancer.Pools) != 2 {
+		t.Fatalf("expected 2 pools, got %d", len(cfg.Balancer.Pools))
+	}
+	api, static := cfg.Balancer.Pools[0], cfg.Balancer.Pools[1]
+
+	if api.Name != "api" || api.Strategy != "maglev" {
+		t.Errorf("api: pool settings not applied: %+v", api)
+	}
+	// Вложенные секции объединяются по ключам: path задан пулом, timeout унаследован.
+	if api.HealthCheck.Path != "/healthz" || api.HealthCheck.Timeout != 2*time.Second {
+		t.Errorf("api: health check not merged: %+v", api.HealthCheck)
+	}
+	if !api.CircuitBreaker.Enabled || api.CircuitBreaker.MinRequests != 10 {
+		t.Errorf("api: circuit breaker not inherited: %+v", api.CircuitBreaker)
+	}
+	if len(api.Backends) != 1 || api.Backends[0].URL != "http://api:8081" || api.Backends[0].Weight != 3 {
+		t.Errorf("api: unexpected backends %+v", api.Backends)
+	}
+	// Имя cookie привязки не наследуется, остальные настройки привязки - наследуются.
+	if s := api.StickySession; !s.Enabled || s.SigningKey != "secret" || s.CookieName != "" {
+		t.Errorf("api: unexpected sticky session %+v", s)
+	}
+
+	if static.Strategy != "least-connections" || static.HealthCheck.Path != "/health" {
+		t.Errorf("static: balancer settings not inherited: %+v", static.BalancerConfig)
+	}
+	if len(static.Backends) != 0 {
+		t.Errorf("static: backends must not be inherited, got %+v", static.Backends)
+	}
+	if s := static.StickySession; !s.Enabled || s.SigningKey != "secret" || s.CookieName != "static_session" {
+		t.Errorf("static: unexpected sticky session %+v", s)
+	}
+
+	// Секция balancer не меняется пулами.
+	if cfg.Balancer.Strategy != "least-connections" || cfg.Balancer.HealthCheck.Path != "/health" ||
+		cfg.Balancer.StickySession.CookieName != "lb_session" {
+		t.Errorf("balancer section changed by pools: %+v", cfg.Balancer)
+	}
+}
+
+func TestConfigAcceptsPlainStringBackends(t *testing.T) {
+	cfg, _ := loadConfig(t, `
+balancer:
+  backends:
+    - http://backend1:8081
+    - url: http://backend2:8082
+      weight: 3
+  pools:
+    - name: static
+      backends:
+        - http://static1:8091
+`)
+
+	want := []config.BackendConfig{
+		{URL: "http://backend1:8081", Weight: 1},
+		{URL: "http://backend2:8082", Weight: 3},
+	}
+	if !reflect.DeepEqual(cfg.Balancer.Backends, want) {
+		t.Fatalf("balancer backends: got %+v, want %+v", cfg.Balancer.Backends, want)
+	}
+
+	pools := cfg.Balancer.Pools
+	if len(pools) != 1 || !reflect.DeepEqual(pools[0].Backends, []config.BackendConfig{{URL: "http://static1:8091", Weight: 1}}) {
+		t.Fatalf("pool backends: got %+v", pools)
+	}
+}
@@ -0,0 +1,93 @@
+package tests
+
+import (
+	"context"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/mirskow/load-balancer/internal/backends"
+	"github.com/mirskow/load-balancer/internal/config"
+	"github.com/mirskow/load-balancer/internal/events"
+)
+
+func TestBackendPublishesOnlyStateChanges(t *testing.T) {
+	bus := events.NewBus()
+	ch, unsubscribe := bus.Subscribe(10)
+	defer unsubscribe()
+
+	u, _ := url.Parse("http://backend0")
+	b := backends.NewBackend(u, 1, nil)
+	b.SetEventBus(bus)
+	b.SetThresholds(1, 2)
+
+	b.ReportFailure(events.ReasonProxyError, nil)
+	b.ReportFailure(events.ReasonProxyError, nil)
+	b.ReportFailure(events.ReasonProxyError, nil)
+	b.ReportSuccess(events.ReasonHealthCheck)
+	b.SetAlive(true, events.ReasonAdmin)
+
+	want := []events.Event{
+		{From: events.StateUp, To: events.StateDown, Reason: events.ReasonProxyError},
+		{From: events.StateDown, To: events.StateUp, Reason: events.ReasonHealthCheck},
+	}
+	for _, w := range want {
+		select {
+		case e := <-ch:
+			if e.From != w.From || e.To != w.To || e.Reason != w.Reason {
+				t.Fatalf("unexpected event %+v, want %+v", e, w)
+			}
+		case <-time.After(time.Second):
+			t.Fatalf("event %+v was not published", w)
+		}
+	}
+
+	select {
+	case e := <-ch:
+		t.Fatalf("unexpected extra event %+v", e)
+	default:
+	}
+}
+
+func TestWebhookSinkRetries(t *testing.T) {
+	received := make(chan events.Event, 1)
+	var attempts atomic.Int32
+
+	hook := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if attempts.Add(1) < 2 {
+			w.WriteHeader(http.StatusInternalServerError)
+			return
+		}
+
+		var e events.Event
+		if err := json.NewDecoder(r.Body).Decode(&e); err != nil {
+			t.Errorf("decode webhook body: %v", err)
+		}
+		received <- e
+	}))
+	defer hook.Close()
+
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+
+	bus := events.NewBus()
+	sink := events.NewWebhookSink(config.WebhookConfig{URL: hook.URL, RetryBackoff: 10 * time.Millisecond})
+	ch, unsubscribe := bus.Subscribe(events.WebhookBuffer)
+	defer unsubscribe()
+	go sink.Run(ctx, ch)
+
+	bus.Publish(events.Event{Backend: "http://backend0", From: events.StateUp, To: events.StateDown})
+
+	select {
+	case e := <-received:
+		if e.Backend != "http://backend0" || e.To != events.StateDown {
+			t.Fatalf("unexpected event %+v", e)
+		}
+	case <-time.After(2 * time.Second):
+		t.Fatal("webhook was not delivered")
+	}
+}
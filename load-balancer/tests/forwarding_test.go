@@ -0,0 +1,103 @@
+package tests
+
+import (
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	"github.com/mirskow/load-balancer/internal/config"
+)
+
+// forwardedHeaders - заголовки, которые бэкенд получил от балансировщика.
+var forwardedHeaders = []string{"X-Forwarded-For", "X-Forwarded-Proto", "X-Forwarded-Host", "Forwarded"}
+
+func TestForwardingHeaders(t *testing.T) {
+	disabled := false
+
+	cases := []struct {
+		name       string
+		cfg        config.ForwardingConfig
+		remoteAddr string
+		host       string
+		prior      map[string]string
+		want       map[string]string
+	}{
+		{
+			// Без секции forwarding X-Forwarded-For добавляется, как и раньше.
+			name:       "defaults",
+			remoteAddr: "192.0.2.1:1234",
+			host:       "example.com",
+			prior:      map[string]string{"X-Forwarded-For": "10.0.0.1"},
+			want:       map[string]string{"X-Forwarded-For": "10.0.0.1, 192.0.2.1"},
+		},
+		{
+			name:       "x-forwarded-for disabled",
+			cfg:        config.ForwardingConfig{XForwardedFor: &disabled},
+			remoteAddr: "192.0.2.1:1234",
+			host:       "example.com",
+			prior:      map[string]string{"X-Forwarded-For": "10.0.0.1"},
+			want:       map[string]string{},
+		},
+		{
+			name:       "all headers",
+			cfg:        config.ForwardingConfig{XForwardedProto: true, XForwardedHost: true, Forwarded: true},
+			remoteAddr: "192.0.2.1:1234",
+			host:       "example.com",
+			want: map[string]string{
+				"X-Forwarded-For":   "192.0.2.1",
+				"X-Forwarded-Proto": "http",
+				"X-Forwarded-Host":  "example.com",
+				"Forwarded":         "for=192.0.2.1;host=example.com;proto=http",
+			},
+		},
+		{
+			// RFC 7239: IPv6 берется в скобки и кавычки, host с портом - в кавычки.
+			name:       "forwarded quoting",
+			cfg:        config.ForwardingConfig{Forwarded: true},
+			remoteAddr: "[2001:db8::1]:5555",
+			host:       "example.com:8080",
+			prior:      map[string]string{"Forwarded": "for=10.0.0.1"},
+			want: map[string]string{
+				"X-Forwarded-For": "2001:db8::1",
+				"Forwarded":       `for=10.0.0.1, for="[2001:db8::1]";host="example.com:8080";proto=http`,
+			},
+		},
+	}
+
+	for _, tc := range cases {
+		forwarding := func(cfg *config.BalancerConfig) { cfg.Forwarding = tc.cfg }
+		lb, _ := newTestBalancer(t, forwarding, http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			got := make(map[string]string)
+			for _, name := range forwardedHeaders {
+				if v := r.Header.Values(name); len(v) > 0 {
+					got[name] = v[0]
+				}
+			}
+			json.NewEncoder(w).Encode(got)
+		}))
+
+		req := httptest.NewRequest(http.MethodGet, "/", nil)
+		req.RemoteAddr = tc.remoteAddr
+		req.Host = tc.host
+		for name, value := range tc.prior {
+			req.Header.Set(name, value)
+		}
+
+		rec := httptest.NewRecorder()
+		lb.Route(rec, req)
+
+		var got map[string]string
+		if err := json.NewDecoder(rec.Body).Decode(&got); err != nil {
+			t.Fatalf("%s: decode backend response: %v", tc.name, err)
+		}
+		if len(got) != len(tc.want) {
+			t.Fatalf("%s: backend got %v, want %v", tc.name, got, tc.want)
+		}
+		for name, want := range tc.want {
+			if got[name] != want {
+				t.Fatalf("%s: %s = %q, want %q", tc.name, name, got[name], want)
+			}
+		}
+	}
+}
@@ -0,0 +1,381 @@
+package tests
+
+import (
+	"context"
+	"errors"
+	"net"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"sync"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/mirskow/load-balancer/internal/backends"
+	"github.com/mirskow/load-balancer/internal/config"
+	"github.com/mirskow/load-balancer/internal/events"
+	"github.com/mirskow/load-balancer/internal/services/balancer"
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/health"
+	healthpb "google.golang.org/grpc/health/grpc_health_v1"
+)
+
+func TestHTTPCheckerStatusAndBody(t *testing.T) {
+	var (
+		mu     sync.Mutex
+		status = http.StatusOK
+		body   = `{"status":"ok","version":"1.2.3"}`
+	)
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		mu.Lock()
+		defer mu.Unlock()
+		if status == http.StatusFound {
+			http.Redirect(w, r, "/login", status)
+			return
+		}
+		w.WriteHeader(status)
+		w.Write([]byte(body))
+	}))
+	defer srv.Close()
+
+	backend := newServerBackend(t, srv.URL)
+	set := func(code int, b string) {
+		mu.Lock()
+		defer mu.Unlock()
+		status, body = code, b
+	}
+
+	cases := []struct {
+		name    string
+		cfg     config.HealthCheckConfig
+		status  int
+		body    string
+		healthy bool
+	}{
+		{"default expects 200", config.HealthCheckConfig{}, http.StatusOK, "", true},
+		{"default rejects 204", config.HealthCheckConfig{}, http.StatusNoContent, "", false},
+		{"range accepts 204", config.HealthCheckConfig{ExpectedStatuses: []string{"200-299"}}, http.StatusNoContent, "", true},
+		{"range rejects 503", config.HealthCheckConfig{ExpectedStatuses: []string{"200-299", "429"}}, http.StatusServiceUnavailable, "", false},
+		{"single code in list", config.HealthCheckConfig{ExpectedStatuses: []string{"200-299", "429"}}, http.StatusTooManyRequests, "", true},
+		{"redirect is not followed", config.HealthCheckConfig{}, http.StatusFound, "", false},
+		{"redirect accepted by range", config.HealthCheckConfig{ExpectedStatuses: []string{"200-399"}}, http.StatusFound, "", true},
+		{"body contains", config.HealthCheckConfig{BodyContains: `"status":"ok"`}, http.StatusOK, `{"status":"ok"}`, true},
+		{"body does not contain", config.HealthCheckConfig{BodyContains: `"status":"ok"`}, http.StatusOK, `{"status":"degraded"}`, false},
+		{"body regex", config.HealthCheckConfig{BodyRegex: `"version":"1\.\d+\.\d+"`}, http.StatusOK, `{"version":"1.2.3"}`, true},
+		{"body regex mismatch", config.HealthCheckConfig{BodyRegex: `"version":"1\.\d+\.\d+"`}, http.StatusOK, `{"version":"2.0.0"}`, false},
+	}
+
+	for _, c := range cases {
+		checker, err := balancer.NewHTTPChecker(c.cfg)
+		if err != nil {
+			t.Fatalf("%s: NewHTTPChecker: %v", c.name, err)
+		}
+
+		set(c.status, c.body)
+		err = checker.Check(context.Background(), backend)
+		if healthy := err == nil; healthy != c.healthy {
+			t.Errorf("%s: healthy = %v (err %v), want %v", c.name, healthy, err, c.healthy)
+		}
+	}
+
+	if _, err := balancer.NewHTTPChecker(config.HealthCheckConfig{ExpectedStatuses: []string{"299-200"}}); err == nil {
+		t.Error("expected error for inverted status range")
+	}
+}
+
+func TestHTTPCheckerRequestOverrides(t *testing.T) {
+	requests := make(chan *http.Request, 1)
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		requests <- r.Clone(context.Background())
+	}))
+	defer srv.Close()
+
+	checker, err := balancer.NewHTTPChecker(config.HealthCheckConfig{
+		Path:    "/healthz",
+		Method:  "head",
+		Host:    "internal.example.com",
+		Headers: map[string]string{"x-health-token": "secret"},
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if err := checker.Check(context.Background(), newServerBackend(t, srv.URL)); err != nil {
+		t.Fatalf("Check: %v", err)
+	}
+
+	got := <-requests
+	if got.Method != http.MethodHead || got.URL.Path != "/healthz" || got.Host != "internal.example.com" || got.Header.Get("X-Health-Token") != "secret" {
+		t.Fatalf("unexpected probe request: %s %s host=%s token=%q", got.Method, got.URL.Path, got.Host, got.Header.Get("X-Health-Token"))
+	}
+}
+
+func TestHTTPCheckerTimeout(t *testing.T) {
+	release := make(chan struct{})
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		select {
+		case <-release:
+		case <-r.Context().Done():
+		}
+	}))
+	defer srv.Close()
+	defer close(release)
+
+	checker, err := balancer.NewHTTPChecker(config.HealthCheckConfig{Timeout: 100 * time.Millisecond})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	start := time.Now()
+	if err := checker.Check(context.Background(), newServerBackend(t, srv.URL)); err == nil {
+		t.Fatal("expected timeout error from hanging backend")
+	}
+	if elapsed := time.Since(start); elapsed > time.Second {
+		t.Fatalf("probe took %s despite 100ms timeout", elapsed)
+	}
+}
+
+// newServerBackend создает бэкенд без прокси для заданного адреса.
+func TestGRPCCheckerReusesConnectionUntilClosed(t *testing.T) {
+	ln, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	counting := newCountingListener(ln)
+
+	srv := grpc.NewServer()
+	healthpb.RegisterHealthServer(srv, health.NewServer())
+	go srv.Serve(counting)
+	defer srv.Stop()
+
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+
+	// Проверки запускаются каждую секунду: две проверки должны пройти по одному соединению.
+	_, err = balancer.NewLoadBalancer(ctx, config.BalancerConfig{
+		HealthCheckTime: 1,
+		HealthCheck:     config.HealthCheckConfig{Type: balancer.HealthCheckGRPC, Timeout: time.Second},
+		Backends:        []config.BackendConfig{{URL: "http://" + ln.Addr().String(), Weight: 1}},
+	}, nil)
+	if err != nil {
+		t.Fatalf("NewLoadBalancer: %v", err)
+	}
+
+	time.Sleep(2500 * time.Millisecond)
+	if accepted := counting.accepted.Load(); accepted != 1 {
+		t.Fatalf("expected probes to share one connection, got %d connections", accepted)
+	}
+
+	// Остановка балансировщика закрывает gRPC-клиент.
+	cancel()
+	select {
+	case <-counting.closed:
+	case <-time.After(2 * time.Second):
+		t.Fatal("gRPC health check connection was not closed on shutdown")
+	}
+}
+
+// countingListener считает принятые соединения и сообщает о закрытии первого из них.
+type countingListener struct {
+	net.Listener
+	accepted  atomic.Int32
+	closed    chan struct{}
+	closeOnce sync.Once
+}
+
+func newCountingListener(ln net.Listener) *countingListener {
+	return &countingListener{Listener: ln, closed: make(chan struct{})}
+}
+
+func (l *countingListener) Accept() (net.Conn, error) {
+	conn, err := l.Listener.Accept()
+	if err != nil {
+		return nil, err
+	}
+	l.accepted.Add(1)
+	return &notifyConn{Conn: conn, onClose: func() { l.closeOnce.Do(func() { close(l.closed) }) }}, nil
+}
+
+type notifyConn struct {
+	net.Conn
+	onClose func()
+}
+
+func (c *notifyConn) Close() error {
+	c.onClose()
+	return c.Conn.Close()
+}
+
+func newServerBackend(t *testing.T, rawURL string) *backends.Backend {
+	t.Helper()
+
+	u, err := url.Parse(rawURL)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return backends.NewBackend(u, 1, nil)
+}
+
+func TestPassiveTrafficDoesNotMaskFailingProbes(t *testing.T) {
+	backend := newServerBackend(t, "http://backend0")
+	backend.SetThresholds(2, 3)
+
+	probeErr := errors.New("unexpected status 503")
+	for i := 0; i < 3; i++ {
+		backend.ReportFailure(events.ReasonHealthCheck, probeErr)
+		for j := 0; j < 5; j++ {
+			backend.ReportSuccess(events.ReasonProxySuccess)
+		}
+	}
+
+	if backend.IsAlive() {
+		t.Fatal("backend with 3 failed probes in a row stayed alive because of proxied traffic")
+	}
+
+	// Успешный трафик не возвращает бэкенд в ротацию - это делают только проверки.
+	for i := 0; i < 5; i++ {
+		backend.ReportSuccess(events.ReasonProxySuccess)
+	}
+	if backend.IsAlive() {
+		t.Fatal("proxied traffic brought a backend back up")
+	}
+
+	backend.ReportSuccess(events.ReasonHealthCheck)
+	if backend.ReportSuccess(events.ReasonHealthCheck); !backend.IsAlive() {
+		t.Fatal("backend not revived after healthy threshold of probes")
+	}
+
+	// Ошибки проксирования считаются отдельно и тоже учитывают порог.
+	backend.ReportFailure(events.ReasonProxyError, probeErr)
+	backend.ReportSuccess(events.ReasonHealthCheck)
+	backend.ReportFailure(events.ReasonProxyError, probeErr)
+	if backend.ReportFailure(events.ReasonProxyError, probeErr); backend.IsAlive() {
+		t.Fatal("3 proxy errors in a row did not mark the backend down")
+	}
+
+	// После смены состояния пороги отсчитываются заново для обоих источников.
+	if backend.ReportSuccess(events.ReasonHealthCheck); backend.IsAlive() {
+		t.Fatal("a single probe success revived the backend below healthy threshold")
+	}
+	if backend.ReportSuccess(events.ReasonHealthCheck); !backend.IsAlive() {
+		t.Fatal("backend not revived after healthy threshold of probes")
+	}
+	if backend.ReportFailure(events.ReasonProxyError, probeErr); !backend.IsAlive() {
+		t.Fatal("a single proxy error after recovery marked the backend down")
+	}
+}
+
+func TestHangingBackendDoesNotDelayOtherProbes(t *testing.T) {
+	var inFlight, maxInFlight atomic.Int32
+	hang := http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.URL.Path == "/healthz" {
+			n := inFlight.Add(1)
+			defer inFlight.Add(-1)
+			for cur := maxInFlight.Load(); n > cur && !maxInFlight.CompareAndSwap(cur, n); cur = maxInFlight.Load() {
+			}
+			<-r.Context().Done()
+			return
+		}
+		w.Write([]byte("hanging"))
+	})
+	failing := http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.URL.Path == "/healthz" {
+			w.WriteHeader(http.StatusServiceUnavailable)
+			return
+		}
+		w.Write([]byte("failing"))
+	})
+
+	lb, _ := newTestBalancer(t, func(cfg *config.BalancerConfig) {
+		cfg.HealthCheckTime = 1
+		cfg.HealthCheck = config.HealthCheckConfig{Path: "/healthz", Timeout: 3 * time.Second}
+	}, hang, hang, hang, failing)
+
+	// Первая проверка стартует через секунду. Зависшие бэкенды держат свои проверки до таймаута (3s),
+	// но проверки идут параллельно, поэтому нерабочий бэкенд выводится из ротации сразу.
+	deadline := time.Now().Add(2500 * time.Millisecond)
+	for {
+		if routeN(lb, 10)["failing"] == 0 {
+			break
+		}
+		if time.Now().After(deadline) {
+			t.Fatal("failing backend still in rotation: its probe waited for hanging backends")
+		}
+		time.Sleep(50 * time.Millisecond)
+	}
+
+	for maxInFlight.Load() < 3 && time.Now().Before(deadline) {
+		time.Sleep(10 * time.Millisecond)
+	}
+	if n := maxInFlight.Load(); n != 3 {
+		t.Fatalf("expected probes of 3 hanging backends to run concurrently, got %d", n)
+	}
+}
+
+func TestTCPChecker(t *testing.T) {
+	ln, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	addr := ln.Addr().String()
+
+	checker, err := balancer.NewHealthChecker(config.HealthCheckConfig{Type: balancer.HealthCheckTCP, Timeout: time.Second})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	backend := newServerBackend(t, "http://"+addr)
+	if err := checker.Check(context.Background(), backend); err != nil {
+		t.Fatalf("expected healthy listener, got %v", err)
+	}
+
+	ln.Close()
+	if err := checker.Check(context.Background(), backend); err == nil {
+		t.Fatal("expected error for closed port")
+	}
+}
+
+func TestGRPCChecker(t *testing.T) {
+	ln, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	healthSrv := health.NewServer()
+	healthSrv.SetServingStatus("api", healthpb.HealthCheckResponse_SERVING)
+	healthSrv.SetServingStatus("batch", healthpb.HealthCheckResponse_NOT_SERVING)
+
+	srv := grpc.NewServer()
+	healthpb.RegisterHealthServer(srv, healthSrv)
+	go srv.Serve(ln)
+	defer srv.Stop()
+
+	backend := newServerBackend(t, "http://"+ln.Addr().String())
+	cases := map[string]bool{
+		"":        true, // состояние сервера в целом
+		"api":     true,
+		"batch":   false,
+		"unknown": false,
+	}
+	for service, healthy := range cases {
+		checker, err := balancer.NewHealthChecker(config.HealthCheckConfig{
+			Type:        balancer.HealthCheckGRPC,
+			GRPCService: service,
+			Timeout:     time.Second,
+		})
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		if err := checker.Check(context.Background(), backend); (err == nil) != healthy {
+			t.Fatalf("service %q: expected healthy=%t, got %v", service, healthy, err)
+		}
+	}
+
+	healthSrv.Shutdown()
+	checker := balancer.NewGRPCChecker("api", time.Second)
+	if err := checker.Check(context.Background(), backend); err == nil {
+		t.Fatal("expected error after health server shutdown")
+	}
+}
@@ -0,0 +1,250 @@
+package tests
+
+import (
+	"fmt"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/mirskow/load-balancer/internal/config"
+)
+
+func TestHedgedStreamSurvivesClientDisconnect(t *testing.T) {
+	stream := http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.URL.Path == "/ping" {
+			w.Write([]byte("pong"))
+			return
+		}
+		for i := 0; i < 50; i++ {
+			if _, err := w.Write([]byte("chunk\n")); err != nil {
+				return
+			}
+			w.(http.Flusher).Flush()
+
+			select {
+			case <-r.Context().Done():
+				return
+			case <-time.After(20 * time.Millisecond):
+			}
+		}
+	})
+
+	lb, _ := newTestBalancer(t, func(cfg *config.BalancerConfig) {
+		cfg.Hedging = config.HedgingConfig{Enabled: true, PathPattern: "^/", Delay: 10 * time.Millisecond}
+	}, stream, stream)
+
+	front := httptest.NewServer(http.HandlerFunc(lb.Route))
+	defer front.Close()
+
+	// Клиент читает начало потока и отключается: копирование тела в попытке обрывается паникой
+	// http.ErrAbortHandler, которая не должна уронить процесс.
+	for i := 0; i < 5; i++ {
+		resp, err := http.Get(front.URL + "/stream")
+		if err != nil {
+			t.Fatal(err)
+		}
+		buf := make([]byte, 6)
+		if _, err := io.ReadFull(resp.Body, buf); err != nil {
+			t.Fatal(err)
+		}
+		resp.Body.Close()
+	}
+
+	time.Sleep(100 * time.Millisecond)
+
+	resp, err := http.Get(front.URL + "/ping")
+	if err != nil {
+		t.Fatalf("balancer stopped serving after client disconnect: %v", err)
+	}
+	defer resp.Body.Close()
+
+	body, _ := io.ReadAll(resp.Body)
+	if resp.StatusCode != http.StatusOK || string(body) != "pong" {
+		t.Fatalf("unexpected response %d %q", resp.StatusCode, body)
+	}
+}
+
+func TestHedgedRequestUsesFasterBackend(t *testing.T) {
+	var slow atomic.Bool
+	slow.Store(true)
+	cancelled := make(chan struct{}, 1)
+
+	lb, _ := newTestBalancer(t, func(cfg *config.BalancerConfig) {
+		cfg.Hedging = config.HedgingConfig{Enabled: true, PathPattern: "^/", Delay: 50 * time.Millisecond}
+	}, raceBackends(&slow, cancelled, false)...)
+
+	start := time.Now()
+	rec := httptest.NewRecorder()
+	lb.Route(rec, httptest.NewRequest(http.MethodGet, "/", nil))
+	elapsed := time.Since(start)
+
+	if rec.Body.String() != "fast" {
+		t.Fatalf("client got %q instead of the faster response", rec.Body.String())
+	}
+	if elapsed < 50*time.Millisecond || elapsed >= 250*time.Millisecond {
+		t.Fatalf("hedged response took %s, expected hedge after 50ms delay", elapsed)
+	}
+
+	select {
+	case <-cancelled:
+	case <-time.After(time.Second):
+		t.Fatal("losing attempt was not cancelled")
+	}
+
+	// Бэкенд, ответивший быстрее задержки, не получает дублирующих запросов.
+	var hits atomic.Int32
+	fast := http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		hits.Add(1)
+	})
+	lb, _ = newTestBalancer(t, func(cfg *config.BalancerConfig) {
+		cfg.Hedging = config.HedgingConfig{Enabled: true, PathPattern: "^/", Delay: 200 * time.Millisecond}
+	}, fast, fast)
+
+	routeN(lb, 6)
+	if n := hits.Load(); n != 6 {
+		t.Fatalf("expected 6 backend requests without hedges, got %d", n)
+	}
+}
+
+func TestHedgedRequestIgnoresEarlyHints(t *testing.T) {
+	var slow atomic.Bool
+	slow.Store(true)
+
+	lb, _ := newTestBalancer(t, func(cfg *config.BalancerConfig) {
+		cfg.Hedging = config.HedgingConfig{Enabled: true, PathPattern: "^/", Delay: 50 * time.Millisecond}
+	}, raceBackends(&slow, nil, true)...)
+
+	front := httptest.NewServer(http.HandlerFunc(lb.Route))
+	defer front.Close()
+
+	// Медленный бэкенд сразу присылает 103 Early Hints: промежуточный ответ не должен выиграть гонку.
+	start := time.Now()
+	resp, err := http.Get(front.URL)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer resp.Body.Close()
+
+	body, _ := io.ReadAll(resp.Body)
+	if resp.StatusCode != http.StatusOK || string(body) != "fast" {
+		t.Fatalf("client got %d %q instead of the faster response", resp.StatusCode, body)
+	}
+	if elapsed := time.Since(start); elapsed >= 250*time.Millisecond {
+		t.Fatalf("early hints from the slow backend delayed the response for %s", elapsed)
+	}
+}
+
+func TestHedgedWinnerIsPinned(t *testing.T) {
+	var slow atomic.Bool
+	slow.Store(true)
+
+	lb, _ := newTestBalancer(t, func(cfg *config.BalancerConfig) {
+		cfg.StickySession = config.StickySessionConfig{Enabled: true, SigningKey: stickyTestKey}
+		cfg.Hedging = config.HedgingConfig{Enabled: true, PathPattern: "^/", Delay: 50 * time.Millisecond}
+	}, raceBackends(&slow, nil, false)...)
+
+	first := routeWithCookie(lb, nil)
+	cookie := stickyCookie(t, first)
+	if n := len(first.Result().Cookies()); n != 1 {
+		t.Fatalf("expected a single sticky cookie, got %d", n)
+	}
+
+	// Клиент привязан к бэкенду, ответ которого он получил, а не к бэкенду первой попытки.
+	slow.Store(false)
+	if winner, next := first.Header().Get("X-Backend"), routeWithCookie(lb, cookie).Header().Get("X-Backend"); next != winner {
+		t.Fatalf("client pinned to %s, but its response came from %s", next, winner)
+	}
+}
+
+func TestHedgeDelayFollowsObservedPercentile(t *testing.T) {
+	var slow atomic.Bool
+	slow.Store(true)
+
+	// Заголовки приходят сразу, а тело - через 100ms: задержка дублирования считается по времени
+	// до заголовков, а не по времени всего ответа.
+	respond := func(w http.ResponseWriter, name string) {
+		w.WriteHeader(http.StatusOK)
+		w.(http.Flusher).Flush()
+		time.Sleep(100 * time.Millisecond)
+		w.Write([]byte(name))
+	}
+	slowBackend := http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if slow.Load() {
+			select {
+			case <-r.Context().Done():
+				return
+			case <-time.After(300 * time.Millisecond):
+			}
+		}
+		respond(w, "slow")
+	})
+	fastBackend := http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		respond(w, "fast")
+	})
+
+	lb, _ := newTestBalancer(t, func(cfg *config.BalancerConfig) {
+		cfg.Hedging = config.HedgingConfig{Enabled: true, PathPattern: "^/", Delay: time.Hour, DelayPercentile: 50}
+	}, slowBackend, fastBackend)
+
+	timeRequest := func() time.Duration {
+		start := time.Now()
+		lb.Route(httptest.NewRecorder(), httptest.NewRequest(http.MethodGet, "/", nil))
+		return time.Since(start)
+	}
+
+	// Пока замеров меньше 20, действует заданная задержка: медленный бэкенд не дублируется.
+	if elapsed := max(timeRequest(), timeRequest()); elapsed < 400*time.Millisecond {
+		t.Fatalf("request was hedged before enough samples were collected (%s)", elapsed)
+	}
+
+	slow.Store(false)
+	routeN(lb, 20)
+	slow.Store(true)
+
+	// Медианное время до заголовков - доли миллисекунды, поэтому медленный бэкенд дублируется сразу
+	// и ответ занимает около 100ms передачи тела.
+	for i := 0; i < 4; i++ {
+		if elapsed := timeRequest(); elapsed >= 170*time.Millisecond {
+			t.Fatalf("request %d took %s, hedge delay does not follow observed percentile", i, elapsed)
+		}
+	}
+}
+
+// raceBackends создает два бэкенда, которые отвечают заголовком X-Backend со своим именем.
+// Пока slow = true, нечетные обращения (первая попытка запроса) ждут 300ms и отвечают "slow",
+// четные (дублирующая попытка) сразу отвечают "fast". Отмена медленной попытки передается в cancelled.
+// Если earlyHints = true, медленная попытка сразу присылает 103 Early Hints.
+func raceBackends(slow *atomic.Bool, cancelled chan<- struct{}, earlyHints bool) []http.Handler {
+	var arrivals atomic.Int32
+
+	handlers := make([]http.Handler, 2)
+	for i := range handlers {
+		name := fmt.Sprintf("backend%d", i)
+		handlers[i] = http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			w.Header().Set("X-Backend", name)
+			if !slow.Load() || arrivals.Add(1)%2 == 0 {
+				w.Write([]byte("fast"))
+				return
+			}
+
+			if earlyHints {
+				w.Header().Add("Link", "</style.css>; rel=preload; as=style")
+				w.WriteHeader(http.StatusEarlyHints)
+			}
+
+			select {
+			case <-r.Context().Done():
+				if cancelled != nil {
+					cancelled <- struct{}{}
+				}
+				return
+			case <-time.After(300 * time.Millisecond):
+			}
+			w.Write([]byte("slow"))
+		})
+	}
+	return handlers
+}
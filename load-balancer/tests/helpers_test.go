@@ -0,0 +1,41 @@
+package tests
+
+import (
+	"context"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	"github.com/mirskow/load-balancer/internal/config"
+	"github.com/mirskow/load-balancer/internal/services/balancer"
+)
+
+// newTestBalancer запускает httptest-сервер на каждый обработчик и создает балансировщик с этими бэкендами.
+// Активные проверки по умолчанию не запускаются (HealthCheckTime = 100). configure, если задан,
+// меняет конфигурацию перед созданием балансировщика. Серверы возвращаются в порядке обработчиков.
+func newTestBalancer(t *testing.T, configure func(*config.BalancerConfig), handlers ...http.Handler) (*balancer.LoadBalancer, []*httptest.Server) {
+	t.Helper()
+
+	ctx, cancel := context.WithCancel(context.Background())
+	t.Cleanup(cancel)
+
+	cfg := config.BalancerConfig{HealthCheckTime: 100}
+	servers := make([]*httptest.Server, 0, len(handlers))
+	for _, h := range handlers {
+		srv := httptest.NewServer(h)
+		t.Cleanup(srv.Close)
+
+		servers = append(servers, srv)
+		cfg.Backends = append(cfg.Backends, config.BackendConfig{URL: srv.URL, Weight: 1})
+	}
+
+	if configure != nil {
+		configure(&cfg)
+	}
+
+	lb, err := balancer.NewLoadBalancer(ctx, cfg, nil)
+	if err != nil {
+		t.Fatalf("NewLoadBalancer: %v", err)
+	}
+	return lb, servers
+}
@@ -130,8 +130,12 @@ func setupTestRedis(b *testing.B, cfg *config.Config) *redis.Client {
 
 func setupTestServer(b *testing.B, cfg *config.Config, redisClient *redis.Client) *server.Server {
 	repos := repository.NewRepository(redisClient)
-	services := services.NewServices(context.Background(), repos, *cfg)
-	handlers := handler.NewHandler(services)
+	services, err := services.NewServices(context.Background(), repos, *cfg)
+	if err != nil {
+		b.Fatalf("Services initialisation error: %v", err)
+	}
+
+	handlers := handler.NewHandler(services, cfg.Balancer.Forwarding)
 	srv := server.NewServer(cfg.HTTP, handlers)
 
 	go func() {
@@ -164,10 +168,10 @@ func waitForServerReady(b *testing.B, port string) {
 	}
 }
 
-func backendURLs(backends []*httptest.Server) []string {
-	var urls []string
+func backendURLs(backends []*httptest.Server) []config.BackendConfig {
+	var urls []config.BackendConfig
 	for _, s := range backends {
-		urls = append(urls, s.URL)
+		urls = append(urls, config.BackendConfig{URL: s.URL, Weight: 1})
 	}
 	return urls
 }
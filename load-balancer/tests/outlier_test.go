@@ -0,0 +1,129 @@
+package tests
+
+import (
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/mirskow/load-balancer/internal/config"
+	"github.com/mirskow/load-balancer/internal/services/balancer"
+)
+
+func TestOutlierEjectionGrowsWithRepeatedEjections(t *testing.T) {
+	lb, statuses := newStatusBalancer(t, 2, func(cfg *config.BalancerConfig) {
+		cfg.OutlierDetection = config.OutlierDetectionConfig{
+			Enabled:            true,
+			Interval:           time.Hour,
+			Consecutive5xx:     3,
+			BaseEjectionTime:   200 * time.Millisecond,
+			MaxEjectionPercent: 50,
+		}
+	})
+	statuses[0].Store(http.StatusInternalServerError)
+
+	// Round robin отдает backend0 каждый второй запрос: 6 запросов - 3 ответа 5xx подряд.
+	routeN(lb, 6)
+	if hits := routeN(lb, 10); hits["backend0"] != 0 {
+		t.Fatalf("backend0 not ejected after 3 consecutive 5xx: %v", hits)
+	}
+
+	time.Sleep(250 * time.Millisecond)
+	if hits := routeN(lb, 6); hits["backend0"] != 3 {
+		t.Fatalf("backend0 not returned after base ejection time: %v", hits)
+	}
+
+	// Повторное извлечение длится вдвое дольше: через 250ms бэкенд все еще вне ротации.
+	time.Sleep(250 * time.Millisecond)
+	if hits := routeN(lb, 10); hits["backend0"] != 0 {
+		t.Fatalf("second ejection is not longer than the first: %v", hits)
+	}
+
+	time.Sleep(250 * time.Millisecond)
+	if hits := routeN(lb, 10); hits["backend0"] == 0 {
+		t.Fatalf("backend0 not returned after second ejection: %v", hits)
+	}
+}
+
+func TestOutlierEjectionRespectsMaxEjectionPercent(t *testing.T) {
+	lb, statuses := newStatusBalancer(t, 4, func(cfg *config.BalancerConfig) {
+		cfg.OutlierDetection = config.OutlierDetectionConfig{
+			Enabled:            true,
+			Interval:           time.Hour,
+			Consecutive5xx:     1,
+			BaseEjectionTime:   time.Hour,
+			MaxEjectionPercent: 50,
+		}
+	})
+	for _, s := range statuses {
+		s.Store(http.StatusInternalServerError)
+	}
+
+	routeN(lb, 20)
+
+	// Все бэкенды отвечают 5xx, но извлечь можно не больше половины пула.
+	hits := routeN(lb, 20)
+	if len(hits) != 2 {
+		t.Fatalf("expected 2 of 4 backends to stay in rotation, got %v", hits)
+	}
+}
+
+func TestOutlierDetectionIgnoresSuccessfulResponses(t *testing.T) {
+	lb, statuses := newStatusBalancer(t, 2, func(cfg *config.BalancerConfig) {
+		cfg.OutlierDetection = config.OutlierDetectionConfig{
+			Enabled:            true,
+			Interval:           time.Hour,
+			Consecutive5xx:     3,
+			BaseEjectionTime:   time.Hour,
+			MaxEjectionPercent: 50,
+		}
+	})
+
+	// Ответы 5xx, перемежающиеся успешными, не идут подряд и не приводят к извлечению.
+	for i := 0; i < 5; i++ {
+		statuses[0].Store(http.StatusInternalServerError)
+		routeN(lb, 4)
+		statuses[0].Store(http.StatusOK)
+		routeN(lb, 2)
+	}
+
+	if hits := routeN(lb, 10); hits["backend0"] != 5 {
+		t.Fatalf("backend0 ejected without consecutive 5xx: %v", hits)
+	}
+}
+
+// newStatusBalancer создает балансировщик с n бэкендами, которые отвечают своим именем
+// и кодом из statuses[i] (по умолчанию 200). Активные проверки в тесте не запускаются.
+func newStatusBalancer(t *testing.T, n int, configure func(*config.BalancerConfig)) (*balancer.LoadBalancer, []*atomic.Int32) {
+	t.Helper()
+
+	statuses := make([]*atomic.Int32, n)
+	handlers := make([]http.Handler, n)
+	for i := 0; i < n; i++ {
+		name := fmt.Sprintf("backend%d", i)
+		status := &atomic.Int32{}
+		status.Store(http.StatusOK)
+		statuses[i] = status
+
+		handlers[i] = http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			w.WriteHeader(int(status.Load()))
+			w.Write([]byte(name))
+		})
+	}
+
+	lb, _ := newTestBalancer(t, configure, handlers...)
+	return lb, statuses
+}
+
+// routeN отправляет n запросов и возвращает, сколько из них обработал каждый бэкенд.
+func routeN(lb *balancer.LoadBalancer, n int) map[string]int {
+	hits := make(map[string]int)
+	for i := 0; i < n; i++ {
+		rec := httptest.NewRecorder()
+		lb.Route(rec, httptest.NewRequest(http.MethodGet, "/", nil))
+		hits[rec.Body.String()]++
+	}
+	return hits
+}
@@ -0,0 +1,255 @@
+package tests
+
+import (
+	"context"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/mirskow/load-balancer/internal/config"
+	"github.com/mirskow/load-balancer/internal/services/balancer"
+)
+
+// retryTestConfig включает повторы (без ограничений бюджета, если он не задан); неудачные попытки
+// не выводят бэкенд из ротации.
+func retryTestConfig(retry config.RetryConfig) func(*config.BalancerConfig) {
+	retry.Enabled = true
+	if retry.Budget == (config.RetryBudgetConfig{}) {
+		retry.Budget.MinRetriesPerSec = 1000
+	}
+	return func(cfg *config.BalancerConfig) {
+		cfg.Retry = retry
+		cfg.HealthCheck = config.HealthCheckConfig{UnhealthyThreshold: 1000}
+	}
+}
+
+// dropConnection закрывает соединение, не отправляя ответ, - прокси получает ошибку.
+func dropConnection(w http.ResponseWriter, r *http.Request) {
+	conn, _, _ := w.(http.Hijacker).Hijack()
+	conn.Close()
+}
+
+// echoBody отвечает телом запроса.
+func echoBody(w http.ResponseWriter, r *http.Request) {
+	io.Copy(w, r.Body)
+}
+
+func TestRetryMovesIdempotentRequestToAnotherBackend(t *testing.T) {
+	lb, _ := newTestBalancer(t, retryTestConfig(config.RetryConfig{MaxAttempts: 2}),
+		http.HandlerFunc(dropConnection), http.HandlerFunc(echoBody))
+
+	retried := 0
+	for i := 0; i < 6; i++ {
+		rec := httptest.NewRecorder()
+		lb.Route(rec, httptest.NewRequest(http.MethodPut, "/", strings.NewReader("payload")))
+
+		// Тело буферизуется и отправляется повторно целиком.
+		if rec.Code != http.StatusOK || rec.Body.String() != "payload" {
+			t.Fatalf("request %d: got %d %q", i, rec.Code, rec.Body.String())
+		}
+		switch rec.Header().Get("X-LB-Retries") {
+		case "1":
+			retried++
+		case "0":
+		default:
+			t.Fatalf("unexpected X-LB-Retries %q", rec.Header().Get("X-LB-Retries"))
+		}
+	}
+
+	if retried == 0 {
+		t.Fatal("no request was retried")
+	}
+}
+
+func TestRetrySkipsNonIdempotentAndOversizedRequests(t *testing.T) {
+	lb, _ := newTestBalancer(t, retryTestConfig(config.RetryConfig{MaxAttempts: 2, MaxBodyBytes: 8}),
+		http.HandlerFunc(dropConnection), http.HandlerFunc(echoBody))
+
+	cases := map[string]struct {
+		method string
+		body   string
+	}{
+		"post":    {http.MethodPost, "payload"},
+		"too big": {http.MethodPut, "payload-larger-than-limit"},
+	}
+
+	for name, tc := range cases {
+		failed := 0
+		for i := 0; i < 4; i++ {
+			rec := httptest.NewRecorder()
+			lb.Route(rec, httptest.NewRequest(tc.method, "/", strings.NewReader(tc.body)))
+
+			if rec.Header().Get("X-LB-Retries") != "0" {
+				t.Fatalf("%s: unexpected X-LB-Retries %q", name, rec.Header().Get("X-LB-Retries"))
+			}
+			if rec.Code == http.StatusServiceUnavailable {
+				failed++
+				continue
+			}
+			// Тело, не поместившееся в буфер, все равно доходит до бэкенда целиком.
+			if rec.Body.String() != tc.body {
+				t.Fatalf("%s: backend got %q, want %q", name, rec.Body.String(), tc.body)
+			}
+		}
+
+		// Round robin отправляет на нерабочий бэкенд каждый второй запрос.
+		if failed != 2 {
+			t.Fatalf("%s: expected 2 of 4 requests to fail without retry, got %d", name, failed)
+		}
+	}
+}
+
+func TestRetryPerTryTimeoutLimitsOnlyResponseHeaders(t *testing.T) {
+	slowHeaders := http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		select {
+		case <-time.After(300 * time.Millisecond):
+			w.Write([]byte("slow"))
+		case <-r.Context().Done():
+		}
+	})
+	slowBody := http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		for i := 0; i < 3; i++ {
+			w.Write([]byte("chunk"))
+			w.(http.Flusher).Flush()
+			time.Sleep(100 * time.Millisecond)
+		}
+	})
+
+	cfg := retryTestConfig(config.RetryConfig{MaxAttempts: 2, PerTryTimeout: 100 * time.Millisecond})
+
+	// Бэкенд, не приславший заголовки за perTryTimeout, заменяется другим.
+	lb, _ := newTestBalancer(t, cfg, slowHeaders, slowBody)
+	for i := 0; i < 4; i++ {
+		rec := httptest.NewRecorder()
+		lb.Route(rec, httptest.NewRequest(http.MethodGet, "/", nil))
+		if rec.Code != http.StatusOK || rec.Body.String() != "chunkchunkchunk" {
+			t.Fatalf("request %d: got %d %q", i, rec.Code, rec.Body.String())
+		}
+	}
+
+	// Запрос, который нельзя повторить, ждет ответа без таймаута попытки.
+	lb, _ = newTestBalancer(t, cfg, slowHeaders)
+	rec := httptest.NewRecorder()
+	lb.Route(rec, httptest.NewRequest(http.MethodPost, "/", strings.NewReader("payload")))
+	if rec.Code != http.StatusOK || rec.Body.String() != "slow" {
+		t.Fatalf("non-retryable request cut by per-try timeout: %d %q", rec.Code, rec.Body.String())
+	}
+}
+
+func TestRetryBudgetExhaustionAndRefill(t *testing.T) {
+	var failing atomic.Bool
+	handler := http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if failing.Load() {
+			dropConnection(w, r)
+			return
+		}
+		w.Write([]byte("ok"))
+	})
+
+	cfg := retryTestConfig(config.RetryConfig{
+		MaxAttempts: 2,
+		Budget:      config.RetryBudgetConfig{Percent: 50, MinRetriesPerSec: 1, TTL: time.Second},
+	})
+	lb, _ := newTestBalancer(t, cfg, handler, handler)
+
+	retries := func() string {
+		rec := httptest.NewRecorder()
+		lb.Route(rec, httptest.NewRequest(http.MethodGet, "/", nil))
+		return rec.Header().Get("X-LB-Retries")
+	}
+
+	// 4 успешных запроса добавляют к минимальному бюджету (1 повтор за секунду окна) еще 50% - 2 повтора.
+	routeN(lb, 4)
+	failing.Store(true)
+	for i := 0; i < 3; i++ {
+		if got := retries(); got != "1" {
+			t.Fatalf("retry %d within budget: X-LB-Retries = %q", i, got)
+		}
+	}
+	if got := retries(); got != "0" {
+		t.Fatalf("retry allowed after budget exhaustion: X-LB-Retries = %q", got)
+	}
+
+	// Через ttl повторы и успехи выходят из окна - снова доступен только минимальный бюджет.
+	time.Sleep(1100 * time.Millisecond)
+	if got := retries(); got != "1" {
+		t.Fatalf("budget not refilled after ttl: X-LB-Retries = %q", got)
+	}
+	if got := retries(); got != "0" {
+		t.Fatalf("refilled budget exceeds min retries: X-LB-Retries = %q", got)
+	}
+}
+
+func TestRetryBudgetCountsOnlyUsedRetriesAndRealSuccesses(t *testing.T) {
+	// Бюджет без минимума: каждый успешный запрос разрешает один повтор.
+	noMinimum := config.RetryBudgetConfig{Percent: 100, MinRetriesPerSec: -1, TTL: time.Minute}
+
+	handler := http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		switch r.URL.Path {
+		case "/drop":
+			dropConnection(w, r)
+		case "/stream":
+			for i := 0; i < 10; i++ {
+				w.Write([]byte("chunk"))
+				w.(http.Flusher).Flush()
+				time.Sleep(20 * time.Millisecond)
+			}
+		}
+	})
+	lb, _ := newTestBalancer(t, retryTestConfig(config.RetryConfig{MaxAttempts: 2, Budget: noMinimum}), handler, handler)
+
+	// Клиент отключается посреди ответа: такой запрос не пополняет бюджет.
+	for i := 0; i < 3; i++ {
+		ctx, cancel := context.WithTimeout(context.Background(), 50*time.Millisecond)
+		lb.Route(httptest.NewRecorder(), httptest.NewRequest(http.MethodGet, "/stream", nil).WithContext(ctx))
+		cancel()
+	}
+	rec := httptest.NewRecorder()
+	lb.Route(rec, httptest.NewRequest(http.MethodGet, "/drop", nil))
+	if got := rec.Header().Get("X-LB-Retries"); got != "0" {
+		t.Fatalf("cancelled requests refilled the retry budget: X-LB-Retries = %q", got)
+	}
+
+	// Когда бэкендов для повтора не осталось, бюджет не расходуется: 2 повтора в окне хватает
+	// на 2 запроса по 3 попытки к 2 нерабочим бэкендам.
+	budget := config.RetryBudgetConfig{MinRetriesPerSec: 2, TTL: time.Second}
+	lb, _ = newTestBalancer(t, retryTestConfig(config.RetryConfig{MaxAttempts: 3, Budget: budget}),
+		http.HandlerFunc(dropConnection), http.HandlerFunc(dropConnection))
+
+	for i, want := range []string{"1", "1", "0"} {
+		rec := httptest.NewRecorder()
+		lb.Route(rec, httptest.NewRequest(http.MethodGet, "/", nil))
+		if got := rec.Header().Get("X-LB-Retries"); got != want {
+			t.Fatalf("request %d: X-LB-Retries = %q, want %q", i, got, want)
+		}
+	}
+}
+
+func TestRetryBudgetTTL(t *testing.T) {
+	// Минимум повторов считается по дробному числу секунд окна: 2/s за 1.5s - 3 повтора.
+	budget := config.RetryBudgetConfig{MinRetriesPerSec: 2, TTL: 1500 * time.Millisecond}
+	lb, _ := newTestBalancer(t, retryTestConfig(config.RetryConfig{MaxAttempts: 2, Budget: budget}),
+		http.HandlerFunc(dropConnection), http.HandlerFunc(dropConnection))
+
+	for i, want := range []string{"1", "1", "1", "0"} {
+		rec := httptest.NewRecorder()
+		lb.Route(rec, httptest.NewRequest(http.MethodGet, "/", nil))
+		if got := rec.Header().Get("X-LB-Retries"); got != want {
+			t.Fatalf("request %d: X-LB-Retries = %q, want %q", i, got, want)
+		}
+	}
+
+	for _, ttl := range []time.Duration{5 * time.Nanosecond, 500 * time.Millisecond} {
+		cfg := config.BalancerConfig{
+			Backends: []config.BackendConfig{{URL: "http://backend0", Weight: 1}},
+			Retry:    config.RetryConfig{Enabled: true, Budget: config.RetryBudgetConfig{TTL: ttl}},
+		}
+		if _, err := balancer.NewLoadBalancer(context.Background(), cfg, nil); err == nil {
+			t.Fatalf("expected error for retry budget ttl %s", ttl)
+		}
+	}
+}
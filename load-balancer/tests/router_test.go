@@ -0,0 +1,375 @@
+package tests
+
+import (
+	"context"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+
+	"github.com/mirskow/load-balancer/internal/config"
+	"github.com/mirskow/load-balancer/internal/services/balancer"
+)
+
+func TestRouterSelectsPoolByRoute(t *testing.T) {
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+
+	cfg := config.BalancerConfig{
+		HealthCheckTime: 100,
+		Pools: []config.PoolConfig{
+			{Name: "api", BalancerConfig: testPoolConfig(t, "api")},
+			{Name: "static", BalancerConfig: testPoolConfig(t, "static")},
+		},
+		Routes: []config.RouteConfig{
+			{Host: "*.example.com", PathRegex: `^/v\d+/`, Methods: []string{"POST"}, Pool: "api"},
+			{PathPrefix: "/static/", Cookies: []config.MatchConfig{{Name: "beta"}}, Pool: "static"},
+		},
+	}
+
+	router, err := balancer.NewRouter(ctx, cfg, nil)
+	if err != nil {
+		t.Fatalf("NewRouter: %v", err)
+	}
+
+	beta := func(r *http.Request) { r.AddCookie(&http.Cookie{Name: "beta", Value: "1"}) }
+
+	cases := []struct {
+		method, url string
+		modify      func(*http.Request)
+		wantCode    int
+		wantBody    string
+	}{
+		{http.MethodPost, "http://a.example.com:8080/v1/users", nil, http.StatusOK, "api"},
+		{http.MethodGet, "http://a.example.com/v1/users", nil, http.StatusServiceUnavailable, ""},
+		{http.MethodPost, "http://example.com/v1/users", nil, http.StatusServiceUnavailable, ""},
+		{http.MethodGet, "http://cdn/static/app.js", beta, http.StatusOK, "static"},
+		{http.MethodGet, "http://cdn/static/app.js", nil, http.StatusServiceUnavailable, ""},
+	}
+
+	for _, c := range cases {
+		req := httptest.NewRequest(c.method, c.url, nil)
+		if c.modify != nil {
+			c.modify(req)
+		}
+
+		rec := httptest.NewRecorder()
+		router.Route(rec, req)
+
+		if rec.Code != c.wantCode || (c.wantBody != "" && rec.Body.String() != c.wantBody) {
+			t.Errorf("%s %s: got %d %q, want %d %q", c.method, c.url, rec.Code, rec.Body.String(), c.wantCode, c.wantBody)
+		}
+	}
+
+	cfg.Routes[0].Pool = "unknown"
+	if _, err := balancer.NewRouter(ctx, cfg, nil); err == nil {
+		t.Fatal("expected error for route to unknown pool")
+	}
+
+	// Без бэкендов и пулов балансировщик отвечает 503, как и до появления маршрутов.
+	empty, err := balancer.NewRouter(ctx, config.BalancerConfig{HealthCheckTime: 100}, nil)
+	if err != nil {
+		t.Fatalf("NewRouter: %v", err)
+	}
+	rec := httptest.NewRecorder()
+	empty.Route(rec, httptest.NewRequest(http.MethodGet, "/", nil))
+	if rec.Code != http.StatusServiceUnavailable {
+		t.Fatalf("expected 503 without backends, got %d", rec.Code)
+	}
+}
+
+// testPoolConfig создает пул из одного бэкенда, который отвечает своим именем.
+func testPoolConfig(t *testing.T, name string) config.BalancerConfig {
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Write([]byte(name))
+	}))
+	t.Cleanup(srv.Close)
+
+	return config.BalancerConfig{
+		HealthCheckTime: 100,
+		Backends:        []config.BackendConfig{{URL: srv.URL, Weight: 1}},
+	}
+}
+
+func TestRouteRewritesRequestAndResponse(t *testing.T) {
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("X-Seen-Path", r.URL.Path)
+		w.Header().Set("X-Seen-Tenant", r.Header.Get("X-Tenant"))
+		w.Header().Set("X-Seen-Debug", r.Header.Get("X-Debug"))
+		w.Header().Set("Server", "backend/1.0")
+		w.Header().Set("Location", "http://svc.internal:8081/login?next=%2F")
+		w.Header().Add("Set-Cookie", "sid=1; Path=/; Domain=.svc.internal; HttpOnly")
+		w.WriteHeader(http.StatusFound)
+	}))
+	defer srv.Close()
+
+	cfg := config.BalancerConfig{
+		HealthCheckTime: 100,
+		Pools: []config.PoolConfig{{Name: "svc", BalancerConfig: config.BalancerConfig{
+			HealthCheckTime: 100,
+			Backends:        []config.BackendConfig{{URL: srv.URL, Weight: 1}},
+		}}},
+		Routes: []config.RouteConfig{{
+			PathPrefix: "/service-x/",
+			Pool:       "svc",
+			Rewrite: config.RewriteConfig{
+				StripPrefix: "/service-x",
+				RequestHeaders: config.HeaderRewriteConfig{
+					Set:    []config.HeaderValueConfig{{Name: "X-Tenant", Value: "acme"}},
+					Remove: []string{"X-Debug"},
+				},
+				ResponseHeaders: config.HeaderRewriteConfig{Remove: []string{"Server"}},
+				Domains:         []config.DomainRewriteConfig{{From: "svc.internal"}},
+			},
+		}},
+	}
+
+	router, err := balancer.NewRouter(ctx, cfg, nil)
+	if err != nil {
+		t.Fatalf("NewRouter: %v", err)
+	}
+
+	req := httptest.NewRequest(http.MethodGet, "http://example.com/service-x/orders/1", nil)
+	req.Header.Set("X-Debug", "1")
+	rec := httptest.NewRecorder()
+	router.Route(rec, req)
+
+	want := map[string]string{
+		"X-Seen-Path":   "/orders/1",
+		"X-Seen-Tenant": "acme",
+		"X-Seen-Debug":  "",
+		"Server":        "",
+		"Location":      "http://example.com/service-x/login?next=%2F",
+		"Set-Cookie":    "sid=1; Path=/; Domain=example.com; HttpOnly",
+	}
+	for name, value := range want {
+		if got := rec.Header().Get(name); got != value {
+			t.Errorf("%s: got %q, want %q", name, got, value)
+		}
+	}
+}
+
+func TestRouteKeepsLocationWhenPrefixWasNotStripped(t *testing.T) {
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("X-Seen-Path", r.URL.Path)
+		w.Header().Set("Location", "/login")
+		w.WriteHeader(http.StatusFound)
+	}))
+	defer srv.Close()
+
+	cfg := config.BalancerConfig{
+		HealthCheckTime: 100,
+		Pools: []config.PoolConfig{{Name: "svc", BalancerConfig: config.BalancerConfig{
+			HealthCheckTime: 100,
+			Backends:        []config.BackendConfig{{URL: srv.URL, Weight: 1}},
+		}}},
+		Routes: []config.RouteConfig{{
+			PathPrefix: "/service-x",
+			Pool:       "svc",
+			Rewrite:    config.RewriteConfig{StripPrefix: "/service-x"},
+		}},
+	}
+
+	router, err := balancer.NewRouter(ctx, cfg, nil)
+	if err != nil {
+		t.Fatalf("NewRouter: %v", err)
+	}
+
+	// /service-xyz подходит под маршрут, но префикс /service-x снимается только целым сегментом.
+	cases := []struct{ path, seenPath, location string }{
+		{"/service-x/orders", "/orders", "/service-x/login"},
+		{"/service-xyz/orders", "/service-xyz/orders", "/login"},
+	}
+	for _, c := range cases {
+		rec := httptest.NewRecorder()
+		router.Route(rec, httptest.NewRequest(http.MethodGet, "http://example.com"+c.path, nil))
+
+		if got := rec.Header().Get("X-Seen-Path"); got != c.seenPath {
+			t.Errorf("%s: backend saw %q, want %q", c.path, got, c.seenPath)
+		}
+		if got := rec.Header().Get("Location"); got != c.location {
+			t.Errorf("%s: Location %q, want %q", c.path, got, c.location)
+		}
+	}
+}
+
+func TestRouteSplitsTrafficBetweenPools(t *testing.T) {
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+
+	split := config.SplitConfig{
+		Pools:               []config.SplitPoolConfig{{Pool: "stable", Weight: 90}, {Pool: "canary", Weight: 10}},
+		Sticky:              balancer.SplitStickyCookie,
+		OverrideHeader:      "X-LB-Variant",
+		OverrideTrustedNets: []string{"10.0.0.0/8"},
+	}
+	cfg := config.BalancerConfig{
+		HealthCheckTime: 100,
+		StickySession:   config.StickySessionConfig{SigningKey: stickyTestKey},
+		Pools: []config.PoolConfig{
+			{Name: "stable", BalancerConfig: testPoolConfig(t, "stable")},
+			{Name: "canary", BalancerConfig: testPoolConfig(t, "canary")},
+		},
+		Routes: []config.RouteConfig{{PathPrefix: "/", Split: split}},
+	}
+
+	router, err := balancer.NewRouter(ctx, cfg, nil)
+	if err != nil {
+		t.Fatalf("NewRouter: %v", err)
+	}
+
+	route := func(modify func(*http.Request)) *httptest.ResponseRecorder {
+		req := httptest.NewRequest(http.MethodGet, "http://example.com/", nil)
+		if modify != nil {
+			modify(req)
+		}
+		rec := httptest.NewRecorder()
+		router.Route(rec, req)
+		return rec
+	}
+
+	counts := map[string]int{}
+	var canaryCookie *http.Cookie
+	for i := 0; i < 2000; i++ {
+		rec := route(nil)
+		counts[rec.Body.String()]++
+
+		cookies := rec.Result().Cookies()
+		if len(cookies) != 1 || !strings.HasPrefix(cookies[0].Value, rec.Body.String()+".") {
+			t.Fatalf("expected variant cookie for %q, got %v", rec.Body.String(), cookies)
+		}
+		if rec.Body.String() == "canary" {
+			canaryCookie = cookies[0]
+		}
+	}
+	if counts["canary"] < 100 || counts["canary"] > 300 {
+		t.Fatalf("expected ~10%% canary traffic, got %v", counts)
+	}
+
+	withCookie := func(cookie *http.Cookie) func(*http.Request) {
+		return func(r *http.Request) { r.AddCookie(cookie) }
+	}
+	for i := 0; i < 20; i++ {
+		if body := route(withCookie(canaryCookie)).Body.String(); body != "canary" {
+			t.Fatalf("sticky client moved to %s", body)
+		}
+	}
+
+	// Неподписанная или подделанная cookie не выбирает вариант.
+	forged := strings.Replace(canaryCookie.Value, "canary.", "stable.", 1)
+	for _, value := range []string{"canary", forged} {
+		canary := 0
+		for i := 0; i < 200; i++ {
+			if route(withCookie(&http.Cookie{Name: "lb_variant", Value: value})).Body.String() == "canary" {
+				canary++
+			}
+		}
+		if canary > 60 {
+			t.Fatalf("cookie %q chose the variant: %d/200 requests went to canary", value, canary)
+		}
+	}
+
+	split.Pools[1].Weight = 0
+	if err := router.UpdateSplitWeights([]config.RouteConfig{{Split: split}}); err != nil {
+		t.Fatalf("UpdateSplitWeights: %v", err)
+	}
+
+	if body := route(withCookie(canaryCookie)).Body.String(); body != "stable" {
+		t.Fatalf("client stayed on canary with zero weight")
+	}
+
+	override := func(remoteAddr string) func(*http.Request) {
+		return func(r *http.Request) {
+			r.RemoteAddr = remoteAddr
+			r.Header.Set("X-LB-Variant", "canary")
+		}
+	}
+	if body := route(override("10.1.2.3:1234")).Body.String(); body != "canary" {
+		t.Fatalf("override header from trusted network ignored, got %s", body)
+	}
+	if body := route(override("203.0.113.7:1234")).Body.String(); body != "stable" {
+		t.Fatalf("override header from untrusted client honoured, got %s", body)
+	}
+}
+
+func TestRouteSplitValidatesCookieKeyAndOverrideNets(t *testing.T) {
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+
+	splits := map[string]config.SplitConfig{
+		"cookie without signing key":   {Sticky: balancer.SplitStickyCookie},
+		"override without trusted net": {OverrideHeader: "X-LB-Variant"},
+		"bad trusted net":              {OverrideHeader: "X-LB-Variant", OverrideTrustedNets: []string{"10.0.0.0"}},
+	}
+	for name, split := range splits {
+		split.Pools = []config.SplitPoolConfig{{Pool: "stable", Weight: 1}}
+		cfg := config.BalancerConfig{
+			HealthCheckTime: 100,
+			Pools:           []config.PoolConfig{{Name: "stable", BalancerConfig: testPoolConfig(t, "stable")}},
+			Routes:          []config.RouteConfig{{PathPrefix: "/", Split: split}},
+		}
+		if _, err := balancer.NewRouter(ctx, cfg, nil); err == nil {
+			t.Fatalf("%s: expected error", name)
+		}
+	}
+}
+
+func TestRouterScopesStickyCookieByPool(t *testing.T) {
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+
+	// Пулы получают stickySession секции balancer без имени cookie так же, как при разборе файла конфигурации.
+	inherited := config.StickySessionConfig{Enabled: true, SigningKey: stickyTestKey}
+	custom := inherited
+	custom.CookieName = "static_session"
+	shared := inherited
+	shared.CookieName = "lb_session"
+
+	apiCfg := testPoolConfig(t, "api")
+	apiCfg.StickySession = inherited
+	staticCfg := testPoolConfig(t, "static")
+	staticCfg.StickySession = custom
+	sharedCfg := testPoolConfig(t, "shared")
+	sharedCfg.StickySession = shared
+
+	cfg := testPoolConfig(t, "default")
+	cfg.StickySession = shared
+	cfg.Pools = []config.PoolConfig{
+		{Name: "api", BalancerConfig: apiCfg},
+		{Name: "static", BalancerConfig: staticCfg},
+		{Name: "shared", BalancerConfig: sharedCfg},
+	}
+	cfg.Routes = []config.RouteConfig{
+		{PathPrefix: "/api/", Pool: "api"},
+		{PathPrefix: "/static/", Pool: "static"},
+		{PathPrefix: "/shared/", Pool: "shared"},
+	}
+
+	router, err := balancer.NewRouter(ctx, cfg, nil)
+	if err != nil {
+		t.Fatalf("NewRouter: %v", err)
+	}
+
+	want := map[string]string{
+		"/":            "lb_session",
+		"/api/users":   "lb_session_api",
+		"/static/a.js": "static_session",
+		// Имя, совпадающее с именем секции balancer, задано для пула явно и не меняется.
+		"/shared/x": "lb_session",
+	}
+	for path, cookieName := range want {
+		rec := httptest.NewRecorder()
+		router.Route(rec, httptest.NewRequest(http.MethodGet, path, nil))
+
+		cookies := rec.Result().Cookies()
+		if len(cookies) != 1 || cookies[0].Name != cookieName {
+			t.Errorf("%s: expected only cookie %s, got %v", path, cookieName, cookies)
+		}
+	}
+}
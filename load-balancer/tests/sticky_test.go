@@ -0,0 +1,145 @@
+package tests
+
+import (
+	"crypto/hmac"
+	"crypto/sha256"
+	"encoding/base64"
+	"encoding/hex"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"strconv"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/mirskow/load-balancer/internal/config"
+	"github.com/mirskow/load-balancer/internal/services/balancer"
+)
+
+const stickyTestKey = "test-signing-key"
+
+func TestStickySessionPinsClientToBackend(t *testing.T) {
+	lb, servers := newStickyBalancer(t, 3)
+
+	first := routeWithCookie(lb, nil)
+	cookie := stickyCookie(t, first)
+
+	for i := 0; i < 10; i++ {
+		rec := routeWithCookie(lb, cookie)
+		if rec.Body.String() != first.Body.String() {
+			t.Fatalf("pinned client moved from %s to %s", first.Body.String(), rec.Body.String())
+		}
+	}
+
+	// Если привязанный бэкенд умер, запрос уходит по стратегии и клиент привязывается заново.
+	servers[first.Body.String()].Close()
+	routeWithCookie(lb, cookie) // ошибка проксирования помечает бэкенд нерабочим
+
+	rec := routeWithCookie(lb, cookie)
+	if rec.Code != http.StatusOK || rec.Body.String() == first.Body.String() {
+		t.Fatalf("expected fallback to another backend, got %d %q", rec.Code, rec.Body.String())
+	}
+	if repinned := stickyCookie(t, rec); repinned.Value == cookie.Value {
+		t.Fatal("expected client to be pinned to the new backend")
+	}
+}
+
+func TestStickySessionRejectsTamperedAndExpiredCookies(t *testing.T) {
+	lb, servers := newStickyBalancer(t, 2)
+
+	target := servers["backend1"]
+	id := stickyBackendID(target.URL)
+
+	cases := map[string]string{
+		"tampered": signedStickyValue(id, time.Now().Add(time.Hour)) + "x",
+		"foreign":  signedStickyValueWithKey("other-key", id, time.Now().Add(time.Hour)),
+		"expired":  signedStickyValue(id, time.Now().Add(-time.Minute)),
+	}
+
+	for name, value := range cases {
+		// Без действующей привязки round robin чередует бэкенды, с привязкой - всегда backend1.
+		hits := 0
+		for i := 0; i < 10; i++ {
+			rec := routeWithCookie(lb, &http.Cookie{Name: "lb_session", Value: value})
+			if rec.Body.String() == "backend1" {
+				hits++
+			}
+			if len(rec.Result().Cookies()) != 1 {
+				t.Fatalf("%s: expected a fresh sticky cookie", name)
+			}
+		}
+		if hits == 10 {
+			t.Fatalf("%s cookie was honoured", name)
+		}
+	}
+
+	valid := &http.Cookie{Name: "lb_session", Value: signedStickyValue(id, time.Now().Add(time.Hour))}
+	for i := 0; i < 10; i++ {
+		if body := routeWithCookie(lb, valid).Body.String(); body != "backend1" {
+			t.Fatalf("valid cookie not honoured, got %s", body)
+		}
+	}
+}
+
+// newStickyBalancer создает балансировщик с привязкой клиентов и n бэкендами, отвечающими своим именем.
+func newStickyBalancer(t *testing.T, n int) (*balancer.LoadBalancer, map[string]*httptest.Server) {
+	t.Helper()
+
+	handlers := make([]http.Handler, n)
+	for i := range handlers {
+		name := fmt.Sprintf("backend%d", i)
+		handlers[i] = http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			w.Write([]byte(name))
+		})
+	}
+
+	lb, servers := newTestBalancer(t, func(cfg *config.BalancerConfig) {
+		cfg.StickySession = config.StickySessionConfig{Enabled: true, SigningKey: stickyTestKey}
+	}, handlers...)
+
+	byName := make(map[string]*httptest.Server, n)
+	for i, srv := range servers {
+		byName[fmt.Sprintf("backend%d", i)] = srv
+	}
+	return lb, byName
+}
+
+func routeWithCookie(lb *balancer.LoadBalancer, cookie *http.Cookie) *httptest.ResponseRecorder {
+	req := httptest.NewRequest(http.MethodGet, "/", nil)
+	if cookie != nil {
+		req.AddCookie(cookie)
+	}
+	rec := httptest.NewRecorder()
+	lb.Route(rec, req)
+	return rec
+}
+
+func stickyCookie(t *testing.T, rec *httptest.ResponseRecorder) *http.Cookie {
+	t.Helper()
+
+	for _, c := range rec.Result().Cookies() {
+		if c.Name == "lb_session" {
+			return c
+		}
+	}
+	t.Fatal("no sticky cookie in response")
+	return nil
+}
+
+// stickyBackendID и signedStickyValue повторяют формат cookie <id>.<expires>.<HMAC>.
+func stickyBackendID(backendURL string) string {
+	sum := sha256.Sum256([]byte(backendURL))
+	return hex.EncodeToString(sum[:8])
+}
+
+func signedStickyValue(id string, expires time.Time) string {
+	return signedStickyValueWithKey(stickyTestKey, id, expires)
+}
+
+func signedStickyValueWithKey(key, id string, expires time.Time) string {
+	payload := strings.Join([]string{id, strconv.FormatInt(expires.Unix(), 10)}, ".")
+	mac := hmac.New(sha256.New, []byte(key))
+	mac.Write([]byte(payload))
+	return payload + "." + base64.RawURLEncoding.EncodeToString(mac.Sum(nil))
+}
@@ -0,0 +1,326 @@
+package tests
+
+import (
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"sync"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/mirskow/load-balancer/internal/backends"
+	"github.com/mirskow/load-balancer/internal/config"
+	"github.com/mirskow/load-balancer/internal/events"
+	"github.com/mirskow/load-balancer/internal/services/balancer"
+)
+
+func TestWeightedRoundRobinDistribution(t *testing.T) {
+	pool := newTestPool(t, 5, 1, 1)
+	strategy := balancer.NewWeightedRoundRobin()
+
+	got := make([]string, 0, 7)
+	for i := 0; i < 7; i++ {
+		got = append(got, strategy.NextBackend(pool).URL.Host)
+	}
+
+	want := []string{"backend0", "backend0", "backend1", "backend0", "backend2", "backend0", "backend0"}
+	if fmt.Sprint(got) != fmt.Sprint(want) {
+		t.Fatalf("unexpected sequence: got %v, want %v", got, want)
+	}
+}
+
+func TestWeightedRoundRobinForgetsRemovedBackend(t *testing.T) {
+	pool := newTestPool(t, 5, 1)
+	strategy := balancer.NewWeightedRoundRobin()
+
+	// После трех выборов backend0 у backend1 накоплен текущий вес.
+	for i := 0; i < 3; i++ {
+		strategy.NextBackend(pool)
+	}
+
+	pool[1].SetAlive(false, events.ReasonAdmin)
+	for i := 0; i < 5; i++ {
+		if b := strategy.NextBackend(pool); b != pool[0] {
+			t.Fatalf("dead backend selected: %s", b.URL.Host)
+		}
+	}
+
+	// Вернувшийся бэкенд начинает с нулевого текущего веса, а не с веса, накопленного до выхода из ротации.
+	pool[1].SetAlive(true, events.ReasonAdmin)
+	got := make([]string, 0, 6)
+	for i := 0; i < 6; i++ {
+		got = append(got, strategy.NextBackend(pool).URL.Host)
+	}
+
+	want := []string{"backend0", "backend1", "backend0", "backend0", "backend0", "backend0"}
+	if fmt.Sprint(got) != fmt.Sprint(want) {
+		t.Fatalf("unexpected sequence after recovery: got %v, want %v", got, want)
+	}
+}
+
+func TestLeastConnectionsPicksIdleBackend(t *testing.T) {
+	pool := newTestPool(t, 1, 1, 1)
+	pool[0].IncActiveRequests()
+	pool[2].IncActiveRequests()
+
+	strategy := balancer.NewLeastConnections()
+	for i := 0; i < 3; i++ {
+		if b := strategy.NextBackend(pool); b != pool[1] {
+			t.Fatalf("expected %s, got %s", pool[1].URL, b.URL)
+		}
+	}
+}
+
+func TestConsistentHashMovesOnlyDeadBackendKeys(t *testing.T) {
+	pool := newTestPool(t, 1, 1, 1, 1)
+	keyFunc, err := balancer.NewHashKeyFunc(balancer.HashKeyHeader, "X-User")
+	if err != nil {
+		t.Fatal(err)
+	}
+	strategy := balancer.NewConsistentHash(keyFunc, 0)
+
+	before := make(map[string]*backends.Backend)
+	for i := 0; i < 1000; i++ {
+		user := fmt.Sprintf("user-%d", i)
+		before[user] = strategy.NextBackendForRequest(requestWithHeader("X-User", user), pool)
+	}
+
+	dead := pool[1]
+	dead.SetAlive(false, events.ReasonAdmin)
+
+	for user, prev := range before {
+		got := strategy.NextBackendForRequest(requestWithHeader("X-User", user), pool)
+		if got == dead {
+			t.Fatalf("key %s routed to dead backend", user)
+		}
+		if prev != dead && got != prev {
+			t.Fatalf("key %s moved from %s to %s although its backend is alive", user, prev.URL, got.URL)
+		}
+	}
+}
+
+// newTestPool создает пул бэкендов с заданными весами без реальных прокси.
+func newTestPool(t *testing.T, weights ...int) []*backends.Backend {
+	t.Helper()
+
+	pool := make([]*backends.Backend, 0, len(weights))
+	for i, w := range weights {
+		u, err := url.Parse(fmt.Sprintf("http://backend%d", i))
+		if err != nil {
+			t.Fatal(err)
+		}
+		pool = append(pool, backends.NewBackend(u, w, nil))
+	}
+	return pool
+}
+
+func requestWithHeader(name, value string) *http.Request {
+	r := httptest.NewRequest(http.MethodGet, "/", nil)
+	r.Header.Set(name, value)
+	return r
+}
+
+func TestNewStrategyRejectsUnknownName(t *testing.T) {
+	if _, err := balancer.NewStrategy(config.BalancerConfig{Strategy: "random"}); err == nil {
+		t.Fatal("expected error for unknown strategy")
+	}
+
+	strategy, err := balancer.NewStrategy(config.BalancerConfig{})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, ok := strategy.(*balancer.RoundRobin); !ok {
+		t.Fatalf("expected round robin by default, got %T", strategy)
+	}
+}
+
+func TestMaglevBalanceAndMinimalDisruption(t *testing.T) {
+	pool := newTestPool(t, 1, 1, 1, 1, 1)
+	keyFunc, err := balancer.NewHashKeyFunc(balancer.HashKeyHeader, "X-User")
+	if err != nil {
+		t.Fatal(err)
+	}
+	strategy := balancer.NewMaglev(keyFunc, 0)
+
+	const keys = 10000
+	before := make(map[string]*backends.Backend, keys)
+	counts := make(map[*backends.Backend]int)
+	for i := 0; i < keys; i++ {
+		user := fmt.Sprintf("user-%d", i)
+		b := strategy.NextBackendForRequest(requestWithHeader("X-User", user), pool)
+		before[user] = b
+		counts[b]++
+	}
+
+	for b, n := range counts {
+		if n < keys/len(pool)*8/10 || n > keys/len(pool)*12/10 {
+			t.Fatalf("backend %s got %d of %d keys", b.URL, n, keys)
+		}
+	}
+
+	dead := pool[2]
+	dead.SetAlive(false, events.ReasonAdmin)
+
+	moved := 0
+	for user, prev := range before {
+		got := strategy.NextBackendForRequest(requestWithHeader("X-User", user), pool)
+		if got == dead {
+			t.Fatalf("key %s routed to dead backend", user)
+		}
+		if prev != dead && got != prev {
+			moved++
+		}
+	}
+
+	if moved > keys/20 {
+		t.Fatalf("too many keys moved between alive backends: %d", moved)
+	}
+}
+
+func TestSlowStartLimitsRecoveredBackendShare(t *testing.T) {
+	strategies := map[string]balancer.BalancingStrategy{
+		"roundrobin":          balancer.NewRoundRobin(1),
+		"weighted-roundrobin": balancer.NewWeightedRoundRobin(),
+	}
+
+	for name, strategy := range strategies {
+		pool := newTestPool(t, 1, 1)
+		recovered := pool[1]
+		recovered.SetSlowStart(time.Hour, 0.1)
+		recovered.SetAlive(false, events.ReasonHealthCheck)
+		recovered.SetAlive(true, events.ReasonHealthCheck)
+
+		const requests = 2000
+		hits := 0
+		for i := 0; i < requests; i++ {
+			if strategy.NextBackend(pool) == recovered {
+				hits++
+			}
+		}
+
+		// При доле веса ~10% восстановленный бэкенд должен получить заметно меньше половины запросов.
+		if share := float64(hits) / requests; share > 0.2 {
+			t.Fatalf("%s: recovered backend got %.2f of traffic during slow start", name, share)
+		}
+	}
+}
+
+func TestPowerOfTwoChoicesUnderConcurrentCalls(t *testing.T) {
+	pool := newTestPool(t, 1, 1, 1, 1, 1)
+	for i := 0; i < 10; i++ {
+		pool[3].IncActiveRequests()
+	}
+	pool[4].SetAlive(false, events.ReasonAdmin)
+
+	strategy := balancer.NewPowerOfTwoChoices(balancer.ActiveRequestsLoad)
+
+	var (
+		wg       sync.WaitGroup
+		mu       sync.Mutex
+		counts   = make(map[*backends.Backend]int)
+		failures atomic.Int64
+	)
+	for g := 0; g < 16; g++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			local := make(map[*backends.Backend]int)
+			for i := 0; i < 2000; i++ {
+				b := strategy.NextBackend(pool)
+				if b == nil || b == pool[3] || b == pool[4] {
+					failures.Add(1)
+					continue
+				}
+				local[b]++
+			}
+
+			mu.Lock()
+			defer mu.Unlock()
+			for b, n := range local {
+				counts[b] += n
+			}
+		}()
+	}
+	wg.Wait()
+
+	// Самый загруженный бэкенд проигрывает любому сопернику, а мертвый не выбирается вовсе.
+	if n := failures.Load(); n > 0 {
+		t.Fatalf("%d picks returned nil, the busiest or a dead backend", n)
+	}
+	for _, b := range pool[:3] {
+		if counts[b] == 0 {
+			t.Fatalf("backend %s never picked", b.URL)
+		}
+	}
+}
+
+func TestPeakEWMAPrefersFasterBackend(t *testing.T) {
+	pool := newTestPool(t, 1, 1)
+	fast, slow := pool[0], pool[1]
+	for i := 0; i < 5; i++ {
+		fast.ObserveLatency(10 * time.Millisecond)
+		slow.ObserveLatency(50 * time.Millisecond)
+	}
+
+	strategy := balancer.NewPeakEWMA()
+	for i := 0; i < 10; i++ {
+		if b := strategy.NextBackend(pool); b != fast {
+			t.Fatalf("expected faster backend %s, got %s", fast.URL, b.URL)
+		}
+	}
+
+	// Стоимость быстрого бэкенда с очередью: 10ms × 11 > 50ms × 1.
+	for i := 0; i < 10; i++ {
+		fast.IncActiveRequests()
+	}
+	if b := strategy.NextBackend(pool); b != slow {
+		t.Fatalf("expected idle slow backend %s when fast one is queued, got %s", slow.URL, b.URL)
+	}
+
+	// Пиковое среднее сразу реагирует на всплеск задержки.
+	fast.ObserveLatency(time.Second)
+	if got := fast.LatencyEWMA(); got < 900*time.Millisecond {
+		t.Fatalf("peak EWMA did not jump to latency spike: %s", got)
+	}
+}
+
+func TestHashStrategiesSkipExcludedBackendWithoutRemapping(t *testing.T) {
+	keyFunc, err := balancer.NewHashKeyFunc(balancer.HashKeyHeader, "X-User")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	strategies := map[string]balancer.ExcludingStrategy{
+		"consistent-hash": balancer.NewConsistentHash(keyFunc, 0),
+		"maglev":          balancer.NewMaglev(keyFunc, 0),
+	}
+
+	for name, strategy := range strategies {
+		pool := newTestPool(t, 1, 1, 1, 1)
+		skipped := pool[0]
+		isSkipped := func(b *backends.Backend) bool { return b == skipped }
+
+		for i := 0; i < 1000; i++ {
+			r := requestWithHeader("X-User", fmt.Sprintf("user-%d", i))
+			primary := strategy.NextBackendForRequest(r, pool)
+			got := strategy.NextBackendExcluding(r, pool, isSkipped)
+
+			// Исключение одного бэкенда не меняет привязку ключей остальных.
+			if primary != skipped && got != primary {
+				t.Fatalf("%s: key %d moved from %s to %s", name, i, primary.URL, got.URL)
+			}
+			if got == skipped || got == nil {
+				t.Fatalf("%s: key %d routed to excluded backend", name, i)
+			}
+			if again := strategy.NextBackendExcluding(r, pool, isSkipped); again != got {
+				t.Fatalf("%s: fallback for key %d is not stable", name, i)
+			}
+		}
+
+		if b := strategy.NextBackendExcluding(requestWithHeader("X-User", "u"), pool, func(*backends.Backend) bool { return true }); b != nil {
+			t.Fatalf("%s: expected nil when every backend is excluded, got %s", name, b.URL)
+		}
+	}
+}
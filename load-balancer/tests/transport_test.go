@@ -0,0 +1,181 @@
+package tests
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"sync"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"golang.org/x/net/http2"
+	"golang.org/x/net/http2/h2c"
+
+	"github.com/mirskow/load-balancer/internal/config"
+	"github.com/mirskow/load-balancer/internal/services/balancer"
+)
+
+func TestTransportReusesConnectionsAcrossBursts(t *testing.T) {
+	// Каждое новое соединение приходит с нового адреса клиента.
+	var (
+		mu    sync.Mutex
+		conns = make(map[string]bool)
+	)
+	lb, _ := newTestBalancer(t, transportTestConfig(config.TransportConfig{}), http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		mu.Lock()
+		conns[r.RemoteAddr] = true
+		mu.Unlock()
+
+		time.Sleep(20 * time.Millisecond)
+		w.Write([]byte("ok"))
+	}))
+	newConns := func() int {
+		mu.Lock()
+		defer mu.Unlock()
+		return len(conns)
+	}
+
+	const burst = 20
+	routeConcurrently(lb, burst)
+	first := newConns()
+	routeConcurrently(lb, burst)
+
+	// По умолчанию на хост сохраняется до 100 простаивающих соединений (у http.DefaultTransport - 2),
+	// поэтому вторая волна запросов использует соединения первой.
+	if second := newConns() - first; second > 2 {
+		t.Fatalf("second burst opened %d new connections (first opened %d)", second, first)
+	}
+}
+
+func TestTransportLimitsConnectionsPerHost(t *testing.T) {
+	var inFlight, maxInFlight atomic.Int32
+	lb, _ := newTestBalancer(t, transportTestConfig(config.TransportConfig{MaxConnsPerHost: 2}), http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		n := inFlight.Add(1)
+		defer inFlight.Add(-1)
+		for cur := maxInFlight.Load(); n > cur && !maxInFlight.CompareAndSwap(cur, n); cur = maxInFlight.Load() {
+		}
+		time.Sleep(20 * time.Millisecond)
+	}))
+	routeConcurrently(lb, 10)
+
+	if n := maxInFlight.Load(); n > 2 {
+		t.Fatalf("backend served %d requests concurrently, limit is 2 connections", n)
+	}
+}
+
+func TestTransportResponseHeaderTimeout(t *testing.T) {
+	transport := config.TransportConfig{ResponseHeaderTimeout: 50 * time.Millisecond}
+	lb, _ := newTestBalancer(t, transportTestConfig(transport), http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		select {
+		case <-time.After(time.Second):
+		case <-r.Context().Done():
+		}
+	}))
+
+	start := time.Now()
+	rec := httptest.NewRecorder()
+	lb.Route(rec, httptest.NewRequest(http.MethodGet, "/", nil))
+
+	if rec.Code != http.StatusServiceUnavailable {
+		t.Fatalf("expected 503 after response header timeout, got %d", rec.Code)
+	}
+	if elapsed := time.Since(start); elapsed > 500*time.Millisecond {
+		t.Fatalf("request took %s despite 50ms response header timeout", elapsed)
+	}
+}
+
+func TestTransportH2C(t *testing.T) {
+	backend := h2c.NewHandler(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Write([]byte(r.Proto))
+	}), &http2.Server{})
+
+	for cfg, want := range map[config.TransportConfig]string{
+		{}:          "HTTP/1.1",
+		{H2C: true}: "HTTP/2.0",
+	} {
+		lb, _ := newTestBalancer(t, transportTestConfig(cfg), backend)
+
+		rec := httptest.NewRecorder()
+		lb.Route(rec, httptest.NewRequest(http.MethodGet, "/", nil))
+		if rec.Code != http.StatusOK || rec.Body.String() != want {
+			t.Fatalf("h2c=%t: backend saw %d %q, want %q", cfg.H2C, rec.Code, rec.Body.String(), want)
+		}
+	}
+}
+
+// transportTestConfig задает настройки транспорта; ошибки проксирования не выводят бэкенд из ротации.
+func transportTestConfig(transport config.TransportConfig) func(*config.BalancerConfig) {
+	return func(cfg *config.BalancerConfig) {
+		cfg.Transport = transport
+		cfg.HealthCheck = config.HealthCheckConfig{UnhealthyThreshold: 1000}
+	}
+}
+
+// routeConcurrently отправляет n одновременных запросов и ждет их завершения.
+func routeConcurrently(lb *balancer.LoadBalancer, n int) {
+	var wg sync.WaitGroup
+	for i := 0; i < n; i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			lb.Route(httptest.NewRecorder(), httptest.NewRequest(http.MethodGet, "/", nil))
+		}()
+	}
+	wg.Wait()
+}
+
+func TestTransportH2CEnforcesConnectionLimitAndHeaderTimeout(t *testing.T) {
+	var (
+		mu    sync.Mutex
+		conns = make(map[string]bool)
+	)
+	backend := h2c.NewHandler(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		mu.Lock()
+		conns[r.RemoteAddr] = true
+		mu.Unlock()
+
+		switch r.URL.Path {
+		case "/slow-headers":
+			select {
+			case <-time.After(time.Second):
+			case <-r.Context().Done():
+			}
+		case "/slow-body":
+			w.WriteHeader(http.StatusOK)
+			w.(http.Flusher).Flush()
+			time.Sleep(300 * time.Millisecond)
+			w.Write([]byte("body"))
+		default:
+			time.Sleep(20 * time.Millisecond)
+		}
+	}), &http2.Server{MaxConcurrentStreams: 2})
+
+	lb, _ := newTestBalancer(t, transportTestConfig(config.TransportConfig{
+		H2C:                   true,
+		MaxConnsPerHost:       1,
+		ResponseHeaderTimeout: 100 * time.Millisecond,
+	}), backend)
+
+	// Бэкенд принимает 2 потока на соединение, но новые соединения не открываются сверх лимита.
+	routeConcurrently(lb, 10)
+	mu.Lock()
+	n := len(conns)
+	mu.Unlock()
+	if n != 1 {
+		t.Fatalf("h2c opened %d connections, limit is 1", n)
+	}
+
+	start := time.Now()
+	rec := httptest.NewRecorder()
+	lb.Route(rec, httptest.NewRequest(http.MethodGet, "/slow-headers", nil))
+	if rec.Code != http.StatusServiceUnavailable || time.Since(start) > 500*time.Millisecond {
+		t.Fatalf("expected 503 after h2c response header timeout, got %d in %s", rec.Code, time.Since(start))
+	}
+
+	// Таймаут ограничивает только ожидание заголовков, тело передается дольше.
+	rec = httptest.NewRecorder()
+	lb.Route(rec, httptest.NewRequest(http.MethodGet, "/slow-body", nil))
+	if rec.Code != http.StatusOK || rec.Body.String() != "body" {
+		t.Fatalf("slow h2c body cut by response header timeout: %d %q", rec.Code, rec.Body.String())
+	}
+}
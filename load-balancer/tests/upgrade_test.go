@@ -0,0 +1,351 @@
+package tests
+
+import (
+	"bufio"
+	"encoding/binary"
+	"fmt"
+	"io"
+	"net"
+	"net/http"
+	"net/http/httptest"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/mirskow/load-balancer/internal/config"
+	"github.com/mirskow/load-balancer/internal/services/balancer"
+)
+
+const (
+	wsOpText  = 0x1
+	wsOpClose = 0x8
+)
+
+type wsFrame struct {
+	opcode  byte
+	payload []byte
+}
+
+func (f wsFrame) closeCode() int {
+	if f.opcode != wsOpClose || len(f.payload) < 2 {
+		return 0
+	}
+	return int(binary.BigEndian.Uint16(f.payload))
+}
+
+func TestWebSocketClosedGracefullyOnEjection(t *testing.T) {
+	backendFrames := make(chan wsFrame, 10)
+	lb, _ := newTestBalancer(t, func(cfg *config.BalancerConfig) {
+		cfg.OutlierDetection = config.OutlierDetectionConfig{
+			Enabled:          true,
+			Interval:         time.Hour,
+			Consecutive5xx:   1,
+			BaseEjectionTime: time.Hour,
+		}
+		cfg.Upgrade = config.UpgradeConfig{IdleTimeout: 2 * time.Second}
+	}, wsBackend(backendFrames, nil))
+
+	client := dialWebSocket(t, lb)
+
+	// Кадр клиента разбит на две части, и решение закрыть соединение принимается между ними:
+	// кадр Close должен уйти бэкенду только после конца кадра клиента.
+	frame := encodeFrame(wsOpText, []byte("hello world"), true)
+	client.conn.Write(frame[:8])
+
+	rec := httptest.NewRecorder()
+	lb.Route(rec, httptest.NewRequest(http.MethodGet, "/fail", nil))
+	time.Sleep(700 * time.Millisecond)
+
+	client.conn.Write(frame[8:])
+
+	if f := receiveFrame(t, backendFrames); f.opcode != wsOpText || string(f.payload) != "hello world" {
+		t.Fatalf("backend got corrupted frame %d %q", f.opcode, f.payload)
+	}
+	if f := receiveFrame(t, backendFrames); f.closeCode() != 1001 {
+		t.Fatalf("expected close frame 1001 at backend, got opcode %d code %d", f.opcode, f.closeCode())
+	}
+
+	if f := client.read(t); f.opcode != wsOpText || string(f.payload) != "hello world" {
+		t.Fatalf("client got %d %q instead of echo", f.opcode, f.payload)
+	}
+	client.expectClose(t, time.Second)
+}
+
+func TestWebSocketOutlivesServerTimeouts(t *testing.T) {
+	// Бэкенд отвечает на рукопожатие дольше таймаутов сервера балансировщика.
+	slowHandshake := http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		time.Sleep(400 * time.Millisecond)
+		wsBackend(make(chan wsFrame, 10), nil).ServeHTTP(w, r)
+	})
+	lb, _ := newTestBalancer(t, nil, slowHandshake)
+
+	// Таймауты http-сервера ограничивают обычные запросы, но не соединение после смены протокола.
+	front := httptest.NewUnstartedServer(http.HandlerFunc(lb.Route))
+	front.Config.ReadTimeout = 300 * time.Millisecond
+	front.Config.WriteTimeout = 300 * time.Millisecond
+	front.Start()
+	defer front.Close()
+
+	client := dialWebSocketServer(t, front)
+	for i := 0; i < 3; i++ {
+		time.Sleep(250 * time.Millisecond)
+
+		msg := fmt.Sprintf("message %d", i)
+		client.conn.Write(encodeFrame(wsOpText, []byte(msg), true))
+		if f := client.read(t); f.opcode != wsOpText || string(f.payload) != msg {
+			t.Fatalf("message %d: client got %d %q instead of echo", i, f.opcode, f.payload)
+		}
+	}
+}
+
+func TestWebSocketClosedOnTimeoutsAndBackendFailures(t *testing.T) {
+	var healthy atomic.Bool
+	healthy.Store(true)
+
+	cases := []struct {
+		name      string
+		configure func(*config.BalancerConfig)
+		trigger   func(lb *balancer.LoadBalancer)
+		minAge    time.Duration
+		within    time.Duration
+	}{
+		{
+			name: "idle timeout",
+			configure: func(cfg *config.BalancerConfig) {
+				cfg.Upgrade = config.UpgradeConfig{IdleTimeout: 200 * time.Millisecond}
+			},
+			minAge: 200 * time.Millisecond,
+			within: time.Second,
+		},
+		{
+			name: "max lifetime",
+			configure: func(cfg *config.BalancerConfig) {
+				cfg.Upgrade = config.UpgradeConfig{IdleTimeout: time.Minute, MaxLifetime: 300 * time.Millisecond}
+			},
+			minAge: 300 * time.Millisecond,
+			within: time.Second,
+		},
+		{
+			name: "circuit breaker open",
+			configure: func(cfg *config.BalancerConfig) {
+				cfg.CircuitBreaker = config.CircuitBreakerConfig{Enabled: true, MinRequests: 1, OpenDuration: time.Hour}
+				cfg.Upgrade = config.UpgradeConfig{IdleTimeout: 2 * time.Second}
+			},
+			trigger: func(lb *balancer.LoadBalancer) {
+				lb.Route(httptest.NewRecorder(), httptest.NewRequest(http.MethodGet, "/fail", nil))
+			},
+			within: 2 * time.Second,
+		},
+		{
+			name: "backend down",
+			configure: func(cfg *config.BalancerConfig) {
+				cfg.HealthCheckTime = 1
+				cfg.HealthCheck = config.HealthCheckConfig{Path: "/healthz"}
+				cfg.Upgrade = config.UpgradeConfig{IdleTimeout: 2 * time.Second}
+			},
+			trigger: func(*balancer.LoadBalancer) { healthy.Store(false) },
+			within:  3 * time.Second,
+		},
+	}
+
+	for _, tc := range cases {
+		healthy.Store(true)
+		lb, _ := newTestBalancer(t, tc.configure, wsBackend(make(chan wsFrame, 10), &healthy))
+
+		client := dialWebSocket(t, lb)
+		start := time.Now()
+		if tc.trigger != nil {
+			tc.trigger(lb)
+		}
+
+		client.expectClose(t, tc.within)
+		if age := time.Since(start); age < tc.minAge {
+			t.Fatalf("%s: connection closed after %s, before %s", tc.name, age, tc.minAge)
+		}
+	}
+}
+
+// wsBackend - минимальный WebSocket-сервер: отвечает эхом на текстовые кадры и кадром Close на Close.
+// Обычные запросы: /fail отвечает 500, /healthz - 200 или 503 по флагу healthy.
+func wsBackend(frames chan<- wsFrame, healthy *atomic.Bool) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		switch {
+		case r.URL.Path == "/fail":
+			w.WriteHeader(http.StatusInternalServerError)
+			return
+		case r.URL.Path == "/healthz":
+			if healthy != nil && !healthy.Load() {
+				w.WriteHeader(http.StatusServiceUnavailable)
+			}
+			return
+		case r.Header.Get("Upgrade") != "websocket":
+			w.WriteHeader(http.StatusBadRequest)
+			return
+		}
+
+		conn, rw, err := w.(http.Hijacker).Hijack()
+		if err != nil {
+			return
+		}
+		defer conn.Close()
+
+		fmt.Fprint(rw, "HTTP/1.1 101 Switching Protocols\r\nUpgrade: websocket\r\nConnection: Upgrade\r\n\r\n")
+		rw.Flush()
+
+		for {
+			f, err := readFrame(rw.Reader)
+			if err != nil {
+				return
+			}
+			frames <- f
+
+			switch f.opcode {
+			case wsOpText:
+				conn.Write(encodeFrame(wsOpText, f.payload, false))
+			case wsOpClose:
+				conn.Write(encodeFrame(wsOpClose, f.payload, false))
+				return
+			}
+		}
+	})
+}
+
+// receiveFrame возвращает очередной кадр, полученный бэкендом.
+func receiveFrame(t *testing.T, frames <-chan wsFrame) wsFrame {
+	t.Helper()
+
+	select {
+	case f := <-frames:
+		return f
+	case <-time.After(2 * time.Second):
+		t.Fatal("backend received no frame")
+		return wsFrame{}
+	}
+}
+
+type wsClient struct {
+	conn net.Conn
+	r    *bufio.Reader
+}
+
+// dialWebSocket подключается к балансировщику через http-сервер и выполняет рукопожатие WebSocket.
+func dialWebSocket(t *testing.T, lb *balancer.LoadBalancer) *wsClient {
+	t.Helper()
+
+	front := httptest.NewServer(http.HandlerFunc(lb.Route))
+	t.Cleanup(front.Close)
+
+	return dialWebSocketServer(t, front)
+}
+
+// dialWebSocketServer выполняет рукопожатие WebSocket с уже запущенным http-сервером балансировщика.
+func dialWebSocketServer(t *testing.T, front *httptest.Server) *wsClient {
+	t.Helper()
+
+	conn, err := net.Dial("tcp", front.Listener.Addr().String())
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { conn.Close() })
+
+	fmt.Fprint(conn, "GET /ws HTTP/1.1\r\nHost: example.com\r\nUpgrade: websocket\r\nConnection: Upgrade\r\n"+
+		"Sec-WebSocket-Version: 13\r\nSec-WebSocket-Key: dGhlIHNhbXBsZSBub25jZQ==\r\n\r\n")
+
+	r := bufio.NewReader(conn)
+	resp, err := http.ReadResponse(r, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if resp.StatusCode != http.StatusSwitchingProtocols {
+		t.Fatalf("handshake failed: %s", resp.Status)
+	}
+
+	return &wsClient{conn: conn, r: r}
+}
+
+func (c *wsClient) read(t *testing.T) wsFrame {
+	t.Helper()
+
+	c.conn.SetReadDeadline(time.Now().Add(2 * time.Second))
+	f, err := readFrame(c.r)
+	if err != nil {
+		t.Fatalf("read frame: %v", err)
+	}
+	return f
+}
+
+// expectClose ждет от балансировщика кадр Close с кодом 1001 и закрытие соединения.
+func (c *wsClient) expectClose(t *testing.T, within time.Duration) {
+	t.Helper()
+
+	c.conn.SetReadDeadline(time.Now().Add(within))
+	f, err := readFrame(c.r)
+	if err != nil {
+		t.Fatalf("expected close frame, got %v", err)
+	}
+	if f.closeCode() != 1001 {
+		t.Fatalf("expected close frame 1001, got opcode %d code %d", f.opcode, f.closeCode())
+	}
+
+	if _, err := c.r.ReadByte(); err != io.EOF {
+		t.Fatalf("expected connection to be closed after close frame, got %v", err)
+	}
+}
+
+func readFrame(r *bufio.Reader) (wsFrame, error) {
+	var head [2]byte
+	if _, err := io.ReadFull(r, head[:]); err != nil {
+		return wsFrame{}, err
+	}
+
+	size := uint64(head[1] & 0x7f)
+	switch size {
+	case 126:
+		var ext [2]byte
+		if _, err := io.ReadFull(r, ext[:]); err != nil {
+			return wsFrame{}, err
+		}
+		size = uint64(binary.BigEndian.Uint16(ext[:]))
+	case 127:
+		var ext [8]byte
+		if _, err := io.ReadFull(r, ext[:]); err != nil {
+			return wsFrame{}, err
+		}
+		size = binary.BigEndian.Uint64(ext[:])
+	}
+
+	var mask [4]byte
+	masked := head[1]&0x80 != 0
+	if masked {
+		if _, err := io.ReadFull(r, mask[:]); err != nil {
+			return wsFrame{}, err
+		}
+	}
+
+	payload := make([]byte, size)
+	if _, err := io.ReadFull(r, payload); err != nil {
+		return wsFrame{}, err
+	}
+	if masked {
+		for i := range payload {
+			payload[i] ^= mask[i%4]
+		}
+	}
+
+	return wsFrame{opcode: head[0] & 0x0f, payload: payload}, nil
+}
+
+func encodeFrame(opcode byte, payload []byte, masked bool) []byte {
+	frame := []byte{0x80 | opcode, byte(len(payload))}
+	if !masked {
+		return append(frame, payload...)
+	}
+
+	mask := []byte{1, 2, 3, 4}
+	frame[1] |= 0x80
+	frame = append(frame, mask...)
+	for i, b := range payload {
+		frame = append(frame, b^mask[i%4])
+	}
+	return frame
+}